@@ -2,7 +2,7 @@ package gol
 
 import (
 	"fmt"
-	"log"
+	"net"
 	"net/rpc"
 	"time"
 	"uk.ac.bris.cs/gameoflife/stubs"
@@ -15,47 +15,18 @@ type distributorChannels struct {
 	ioIdle     <-chan bool
 	ioFilename chan<- string
 	ioOutput   chan<- uint8
+	ioTurn     chan<- int
 	ioInput    <-chan uint8
+	ioError    <-chan error
+	ioRule     <-chan stubs.Rule
 }
 
-/*func calculateNextState(imageHeight, imageWidth int, world [][]byte) [][]byte {
-	resultWorld := make([][]byte, imageHeight)
-	for i := range resultWorld {
-		resultWorld[i] = make([]byte, imageWidth)
-	}
-	//var sum int
-	for y := 0; y < imageHeight; y++ {
-		for x := 0; x < imageWidth; x++ {
-			sum := (world[(y+imageHeight-1)%imageHeight][(x+imageWidth-1)%imageWidth] / 255) + (world[(y+imageHeight-1)%imageHeight][(x+imageWidth)%imageWidth] / 255) +
-				(world[(y+imageHeight-1)%imageHeight][(x+imageWidth+1)%imageWidth] / 255) + (world[(y+imageHeight)%imageHeight][(x+imageWidth-1)%imageWidth] / 255) +
-				(world[(y+imageHeight)%imageHeight][(x+imageWidth+1)%imageWidth] / 255) + (world[(y+imageHeight+1)%imageHeight][(x+imageWidth-1)%imageWidth] / 255) +
-				(world[(y+imageHeight+1)%imageHeight][(x+imageWidth)%imageWidth] / 255) + (world[(y+imageHeight+1)%imageHeight][(x+imageWidth+1)%imageWidth] / 255)
-			if world[y][x] == 255 {
-				if sum < 2 {
-					resultWorld[y][x] = 0
-				} else if sum == 2 || sum == 3 {
-					resultWorld[y][x] = 255
-				} else {
-					resultWorld[y][x] = 0
-				}
-			} else {
-				if sum == 3 {
-					resultWorld[y][x] = 255
-				} else {
-					resultWorld[y][x] = 0
-				}
-			}
-		}
-	}
-	return resultWorld
-}*/
-
 func calculateAliveCells(imageHeight, imageWidth int, world [][]byte) []util.Cell {
 	var aliveCells []util.Cell
 	for y := 0; y < imageHeight; y++ {
 		for x := 0; x < imageWidth; x++ {
 			if world[y][x] == 255 {
-				aliveCells = append(aliveCells, util.Cell{x, y})
+				aliveCells = append(aliveCells, util.Cell{X: x, Y: y})
 			}
 		}
 	}
@@ -67,74 +38,442 @@ func calculateAliveCells(imageHeight, imageWidth int, world [][]byte) []util.Cel
 // distributor divides the work between workers and interacts with other goroutines.
 func distributor(p Params, c distributorChannels, keyPress <-chan rune) {
 
-	// TODO: Create a 2D slice to store the world.
-	world := make([][]uint8, p.ImageHeight)
-	newWorld := make([][]uint8, p.ImageHeight)
-	for i := range world {
-		world[i] = make([]uint8, p.ImageWidth)
-		newWorld[i] = make([]uint8, p.ImageWidth)
+	client, err := dial(p)
+	if err != nil {
+		fail(c, 0, err)
+		return
 	}
+	defer client.Close()
 
-	c.ioCommand <- ioInput // load initial state from input file
-	// get file name in the format of img.width x img.height
-	// source: taken from test go files
-	c.ioFilename <- fmt.Sprintf("%dx%d", p.ImageWidth, p.ImageHeight)
-	for y := 0; y < p.ImageHeight; y++ {
-		for x := 0; x < p.ImageWidth; x++ {
-			world[y][x] = <-c.ioInput
+	// Animations need every turn, so the session streams them all while the GUI is only sent what p.Stream asks for.
+	animating := p.AnimateTo > 0
+	stream := p.Stream
+	if animating {
+		stream = StreamTurns
+	}
+
+	// Either start a new session from the input image, resume one from its checkpoint or pick up a running one.
+	// New and resumed sessions start paused until any keys pressed in the meantime have been handled.
+	status := new(stubs.Response)
+	var world [][]uint8
+	started := p.Session == "" || p.Resume
+	if p.Resume {
+		request := stubs.RestoreRequest{Session: p.Session, ImageHeight: p.ImageHeight, ImageWidth: p.ImageWidth, Stream: stream.wire(), Paused: true}
+		err := client.Call(stubs.RestoreHandler, request, status)
+		if err != nil {
+			fail(c, 0, err)
+			return
+		}
+		world = status.FinalWorld.Unpack()
+		fmt.Println("Resumed session", status.Session, "from turn", status.CompletedTurns)
+	} else if p.Session == "" {
+		var rule stubs.Rule
+		world, rule, err = loadWorld(p, c)
+		if err != nil {
+			fail(c, 0, err)
+			return
+		}
+		request := stubs.Request{Version: stubs.RequestVersion, Owner: p.Owner, World: stubs.Pack(world), ImageHeight: p.ImageHeight, ImageWidth: p.ImageWidth, Turns: p.Turns, Threads: p.Threads, Rule: rule, Boundary: p.Boundary, Engine: p.Engine, Cycles: p.Cycles, Stream: stream.wire(), Paused: true}
+		err := client.Call(stubs.StartHandler, request, status)
+		if err != nil {
+			fail(c, 0, err)
+			return
+		}
+		fmt.Println("Started session", status.Session)
+	} else {
+		err := client.Call(stubs.AttachHandler, stubs.ControlRequest{Session: p.Session, Stream: stream.wire()}, status)
+		if err != nil {
+			fail(c, 0, err)
+			return
 		}
+		world = status.FinalWorld.Unpack()
+		if len(world) != p.ImageHeight || len(world[0]) != p.ImageWidth {
+			_ = client.Call(stubs.DetachHandler, stubs.ControlRequest{Session: status.Session}, new(stubs.Response))
+			fail(c, status.CompletedTurns, fmt.Errorf("session %v is not %dx%d", p.Session, p.ImageWidth, p.ImageHeight))
+			return
+		}
+		fmt.Println("Attached to session", status.Session)
 	}
+	session := stubs.ControlRequest{Session: status.Session}
 
-	turn := 0
-	c.events <- StateChange{turn, Executing}
+	// Show the starting state in the GUI before any turns are streamed.
+	if p.Stream != StreamOff {
+		c.events <- startingCells(status.CompletedTurns, world)
+	}
+
+	// frame passes the world to the io goroutine as the next frame of the animation if turn is in the range animated.
+	frames, firstFrame, lastFrame := 0, 0, 0
+	frame := func(turn int) {
+		if !animating || turn < p.AnimateFrom || turn > p.AnimateTo {
+			return
+		}
+		if frames == 0 {
+			firstFrame = turn
+		}
+		frames, lastFrame = frames+1, turn
+		c.ioCommand <- ioFrame
+		for y := 0; y < p.ImageHeight; y++ {
+			for x := 0; x < p.ImageWidth; x++ {
+				c.ioOutput <- world[y][x]
+			}
+		}
+	}
+	frame(status.CompletedTurns)
+
+	paused := status.Paused
+	if paused {
+		c.events <- StateChange{status.CompletedTurns, Paused}
+	} else {
+		c.events <- StateChange{status.CompletedTurns, Executing}
+	}
+
+	// handleKey applies a key press to the session, returning true if the controller has detached.
+	shutdown := false
+	handleKey := func(key rune) bool {
+		switch key {
+		case 'p':
+			handler, state := stubs.PauseHandler, Paused
+			if paused {
+				handler, state = stubs.ResumeHandler, Executing
+			}
+			status := new(stubs.Response)
+			err := client.Call(handler, session, status)
+			if err != nil {
+				return false
+			}
+			paused = !paused
+			if paused {
+				fmt.Println("Paused at turn", status.CompletedTurns)
+			} else {
+				fmt.Println("Continuing")
+			}
+			c.events <- StateChange{status.CompletedTurns, state}
+		case 's':
+			snapshot := new(stubs.Response)
+			err := client.Call(stubs.SaveHandler, session, snapshot)
+			if err != nil {
+				return false
+			}
+			outputWorld(p, c, snapshot.FinalWorld.Unpack(), snapshot.CompletedTurns)
+		case 'q':
+			// Leave the session running on the server so that it can be reattached to later.
+			snapshot := new(stubs.Response)
+			err := client.Call(stubs.SaveHandler, session, snapshot)
+			if err != nil {
+				return false
+			}
+			_ = client.Call(stubs.DetachHandler, session, new(stubs.Response))
+			outputWorld(p, c, snapshot.FinalWorld.Unpack(), snapshot.CompletedTurns)
+			if frames > 0 {
+				outputAnimation(p, c, firstFrame, lastFrame)
+			}
+			fmt.Printf("Detached from session %v, reattach with -session %v\n", session.Session, session.Session)
+			c.events <- StateChange{snapshot.CompletedTurns, Quitting}
+			close(c.events)
+			return true
+		case 'k':
+			// The pending Await call returns as soon as the server has stopped.
+			err := client.Call(stubs.QuitHandler, session, new(stubs.Response))
+			if err != nil {
+				return false
+			}
+			shutdown = true
+		}
+		return false
+	}
+
+	if started {
+		for pending := true; pending; {
+			select {
+			case key := <-keyPress:
+				if handleKey(key) {
+					return
+				}
+			default:
+				pending = false
+			}
+		}
+		if !paused {
+			err := client.Call(stubs.ResumeHandler, session, new(stubs.Response))
+			if err != nil {
+				fail(c, 0, err)
+				return
+			}
+		}
+	}
 
 	// TODO: Execute all turns of the Game of Life.
-	/*for turn < p.Turns {
-		newWorld = calculateNextState(p.ImageHeight, p.ImageWidth, world)
-		world = newWorld
-		turn++
-	}*/
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
-	//
-	//mutex := &sync.Mutex{}
-	//done := make(chan bool)
-	//hard coding the server addr
-	server := "127.0.0.1:8030"
 
-	client, err1 := rpc.Dial("tcp", server)
-	if err1 != nil {
-		log.Fatal("dialing:", err1)
+	response := new(stubs.Response)
+	call := client.Go(stubs.AwaitHandler, session, response, nil)
+	done := call.Done
+
+	var diffs chan []stubs.TurnDiff
+	if stream != StreamOff {
+		diffs = make(chan []stubs.TurnDiff)
+		stop := make(chan struct{})
+		defer close(stop)
+		go streamFlipped(p, stream, client, session, diffs, stop)
 	}
-	defer client.Close()
+	lastTurn := status.CompletedTurns
 
-	request := stubs.Request{World: world, NewWorld: newWorld, ImageHeight: p.ImageHeight, ImageWidth: p.ImageWidth, Turns: p.Turns}
-	response := new(stubs.Response)
-	err2 := client.Call(stubs.GolHandler, request, response)
-	if err2 != nil {
-		panic(err2)
+	// reportCycle passes on the cycle the world has settled into the first time the server reports one.
+	cycleReported := false
+	reportCycle := func(res *stubs.Response) {
+		if res.CyclePeriod > 0 && !cycleReported {
+			cycleReported = true
+			c.events <- CycleDetected{res.CompletedTurns, res.CycleStart, res.CyclePeriod}
+		}
 	}
 
-	//go func() {
-	/*for {
+	// Report the number of alive cells every 2 seconds and forward key presses and flipped cells
+	// until the server has finished and every streamed turn has been passed on.
+	for done != nil || diffs != nil {
 		select {
-		case <-a.Done:
-			break
+		case <-done:
+			done = nil
+		case turns, ok := <-diffs:
+			if !ok {
+				diffs = nil
+				continue
+			}
+			for _, diff := range turns {
+				cells := diff.Flipped.Cells()
+				if animating {
+					applyDiff(world, cells, diff.Levels)
+				}
+				if p.Stream != StreamOff && len(cells) > 0 && diff.Levels != nil {
+					c.events <- CellsShaded{diff.CompletedTurns, cells, diff.Levels}
+				} else if p.Stream != StreamOff && len(cells) > 0 {
+					c.events <- CellsFlipped{diff.CompletedTurns, cells}
+				}
+				if diff.CompletedTurns > lastTurn {
+					lastTurn = diff.CompletedTurns
+					if p.Stream != StreamOff {
+						c.events <- TurnComplete{lastTurn}
+					}
+					frame(lastTurn)
+				}
+			}
 		case <-ticker.C:
-			fmt.Println("2 seconds")
-		}
-	}*/
-	//}()
-	//
-	//response := makeCall1(client, world, newWorld, p.ImageHeight, p.ImageWidth, p.Turns)
-	// TODO: Report the final state using FinalTurnCompleteEvent.
-	c.events <- FinalTurnComplete{response.CompletedTurns, calculateAliveCells(p.ImageHeight, p.ImageWidth, response.FinalWorld)}
+			count := new(stubs.Response)
+			err := client.Call(stubs.CellReport, session, count)
+			if err != nil {
+				// The server forgets the session as soon as Await returns, which may be just before this call.
+				// Any other failure also fails Await, which reports it.
+				continue
+			}
+			warn(c, count)
+			c.events <- AliveCellsCount{count.CompletedTurns, count.AliveCells}
+			if p.Engine == stubs.EngineSparse {
+				c.events <- TilesSkipped{count.CompletedTurns, count.SkippedTiles}
+			}
+			reportCycle(count)
+		case key := <-keyPress:
+			if handleKey(key) {
+				return
+			}
+		}
+	}
+	if call.Error != nil {
+		fail(c, response.CompletedTurns, call.Error)
+		return
+	}
+
+	warn(c, response)
+	reportCycle(response)
+
+	// Save the final state and report it using FinalTurnCompleteEvent.
+	final := response.FinalWorld.Unpack()
+	outputWorld(p, c, final, response.CompletedTurns)
+	if frames > 0 {
+		outputAnimation(p, c, firstFrame, lastFrame)
+	}
+	c.events <- FinalTurnComplete{response.CompletedTurns, calculateAliveCells(p.ImageHeight, p.ImageWidth, final)}
 	// Make sure that the Io has finished any output before exiting.
 	c.ioCommand <- ioCheckIdle
 	<-c.ioIdle
-	//done <- true
-	c.events <- StateChange{turn, Quitting}
+	if shutdown {
+		_ = client.Call(stubs.ShutdownHandler, session, new(stubs.Response))
+	}
+	c.events <- StateChange{response.CompletedTurns, Quitting}
 
 	// Close the channel to stop the SDL goroutine gracefully. Removing may cause deadlock.
 	close(c.events)
 }
+
+// streamFlipped collects the cells flipped by the session and passes them on until the session has
+// finished or stop is closed. In StreamFrames mode it asks at most p.FrameRate times a second.
+func streamFlipped(p Params, mode StreamMode, client *rpc.Client, session stubs.ControlRequest, diffs chan<- []stubs.TurnDiff, stop <-chan struct{}) {
+	defer close(diffs)
+	var frames <-chan time.Time
+	if mode == StreamFrames {
+		frameRate := p.FrameRate
+		if frameRate <= 0 {
+			frameRate = DefaultFrameRate
+		}
+		ticker := time.NewTicker(time.Second / time.Duration(frameRate))
+		defer ticker.Stop()
+		frames = ticker.C
+	}
+	for {
+		if frames != nil {
+			select {
+			case <-frames:
+			case <-stop:
+				return
+			}
+		}
+		res := new(stubs.FlippedResponse)
+		err := client.Call(stubs.FlippedHandler, session, res)
+		if err != nil {
+			return
+		}
+		if len(res.Turns) > 0 {
+			select {
+			case diffs <- res.Turns:
+			case <-stop:
+				return
+			}
+		}
+		if res.Done {
+			return
+		}
+	}
+}
+
+// dial connects to the first reachable server in p.Servers, giving each p.Timeout to answer.
+func dial(p Params) (*rpc.Client, error) {
+	servers := p.Servers
+	if len(servers) == 0 {
+		servers = []string{DefaultServer}
+	}
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	var err error
+	for _, server := range servers {
+		var conn net.Conn
+		conn, err = net.DialTimeout("tcp", server, timeout)
+		if err == nil {
+			return rpc.NewClient(conn), nil
+		}
+		fmt.Println("Could not reach", server)
+	}
+	return nil, fmt.Errorf("no server reachable: %v", err)
+}
+
+// fail reports an error that stops the run and shuts the client down.
+func fail(c distributorChannels, turn int, err error) {
+	c.events <- ErrorOccurred{turn, err}
+	c.events <- StateChange{turn, Quitting}
+	close(c.events)
+}
+
+// warn passes on the warnings the server sent with res.
+func warn(c distributorChannels, res *stubs.Response) {
+	for _, message := range res.Warnings {
+		c.events <- Warning{res.CompletedTurns, message}
+	}
+}
+
+// loadWorld reads the initial state of the world from the input image or pattern, along with the rule to run it under.
+func loadWorld(p Params, c distributorChannels) ([][]uint8, stubs.Rule, error) {
+	// TODO: Create a 2D slice to store the world.
+	world := make([][]uint8, p.ImageHeight)
+	for i := range world {
+		world[i] = make([]uint8, p.ImageWidth)
+	}
+
+	c.ioCommand <- ioInput // load initial state from input file
+	// get file name in the format of img.width x img.height
+	// source: taken from test go files
+	// Images and patterns given by path are read from there instead.
+	switch {
+	case p.Pattern != "":
+		c.ioFilename <- p.Pattern
+	case p.Image != "":
+		c.ioFilename <- p.Image
+	default:
+		c.ioFilename <- fmt.Sprintf("images/%dx%d.pgm", p.ImageWidth, p.ImageHeight)
+	}
+	if err := <-c.ioError; err != nil {
+		return nil, p.Rule, err
+	}
+	for y := 0; y < p.ImageHeight; y++ {
+		for x := 0; x < p.ImageWidth; x++ {
+			world[y][x] = <-c.ioInput
+		}
+	}
+	if p.Pattern == "" {
+		return world, p.Rule, nil
+	}
+	rule := <-c.ioRule
+	if rule != p.Rule.OrLife() {
+		fmt.Println("Using rule", rule, "from", p.Pattern)
+	}
+	return world, rule, nil
+}
+
+// startingCells returns the event that shows world in a blank GUI: CellsFlipped if every cell
+// is alive or dead, or CellsShaded if some are dying under a Generations rule.
+func startingCells(turn int, world [][]uint8) Event {
+	var cells []util.Cell
+	var levels []uint8
+	shaded := false
+	for y, row := range world {
+		for x, level := range row {
+			if level != 0 {
+				cells = append(cells, util.Cell{X: x, Y: y})
+				levels = append(levels, level)
+				shaded = shaded || level != 255
+			}
+		}
+	}
+	if shaded {
+		return CellsShaded{turn, cells, levels}
+	}
+	return CellsFlipped{turn, cells}
+}
+
+// applyDiff flips cells between alive and dead in world, or under Generations rules sets them to levels.
+func applyDiff(world [][]uint8, cells []util.Cell, levels []uint8) {
+	for i, cell := range cells {
+		if levels != nil {
+			world[cell.Y][cell.X] = levels[i]
+		} else {
+			world[cell.Y][cell.X] = 255 - world[cell.Y][cell.X]
+		}
+	}
+}
+
+// outputAnimation writes the frames passed to the io goroutine to out/<width>x<height>x<from>-<to>.gif
+// and reports it once the file is complete.
+func outputAnimation(p Params, c distributorChannels, from, to int) {
+	filename := fmt.Sprintf("%dx%dx%d-%d", p.ImageWidth, p.ImageHeight, from, to)
+	c.ioCommand <- ioAnimation
+	c.ioFilename <- filename
+	c.ioCommand <- ioCheckIdle
+	<-c.ioIdle
+	c.events <- ImageOutputComplete{to, filename}
+}
+
+// outputWorld writes world to out/<width>x<height>x<turn> through the io goroutine, with the extension of p.Format,
+// and reports it once the file is complete.
+func outputWorld(p Params, c distributorChannels, world [][]uint8, turn int) {
+	filename := fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, turn)
+	c.ioCommand <- ioOutput
+	c.ioFilename <- filename
+	c.ioTurn <- turn
+	for y := 0; y < p.ImageHeight; y++ {
+		for x := 0; x < p.ImageWidth; x++ {
+			c.ioOutput <- world[y][x]
+		}
+	}
+	c.ioCommand <- ioCheckIdle
+	<-c.ioIdle
+	c.events <- ImageOutputComplete{turn, filename}
+}
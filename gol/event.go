@@ -22,6 +22,21 @@ type AliveCellsCount struct { // implements Event
 	CellsCount     int
 }
 
+// `TilesSkipped` is an Event notifying the user about the fraction of tiles the sparse engine left alone,
+// because nothing near them had changed, since the last `TilesSkipped`. It is sent alongside `AliveCellsCount`.
+type TilesSkipped struct { // implements Event
+	CompletedTurns int
+	Fraction       float64
+}
+
+// `CycleDetected` is an Event notifying the user that the world has settled into a still life or an oscillator,
+// repeating itself every Period turns from turn Start. It is sent once, when the server first reports the cycle.
+type CycleDetected struct { // implements Event
+	CompletedTurns int
+	Start          int
+	Period         int
+}
+
 // `ImageOutputComplete` is an Event notifying the user about the completion of output.
 // This Event should be sent every time an image has been saved.
 type ImageOutputComplete struct { // implements Event
@@ -63,6 +78,15 @@ type CellsFlipped struct { // implements Event
 	Cells          []util.Cell
 }
 
+// `CellsShaded` is an Event notifying the GUI that the listed cells have changed to the given grey levels.
+// It is sent instead of `CellsFlipped` for Generations rules, where dying cells fade out through shades of grey
+// rather than flipping straight from alive to dead. Levels[i] is the new level of Cells[i].
+type CellsShaded struct { // implements Event
+	CompletedTurns int
+	Cells          []util.Cell
+	Levels         []uint8
+}
+
 // `TurnComplete` is an Event notifying the GUI about turn completion.
 // SDL will render a frame when this event is sent.
 // All `CellFlipped` or `CellsFlipped` events must be sent *before* `TurnComplete`.
@@ -78,6 +102,20 @@ type FinalTurnComplete struct {
 	Alive          []util.Cell
 }
 
+// `ErrorOccurred` is an Event notifying the user that the run could not continue.
+// A `StateChange` to Quitting is always sent straight after it.
+type ErrorOccurred struct {
+	CompletedTurns int
+	Err            error
+}
+
+// `Warning` is an Event notifying the user of a problem the run has recovered from,
+// such as a worker being lost. The run carries on as normal.
+type Warning struct {
+	CompletedTurns int
+	Message        string
+}
+
 // String methods allow the different types of Events and States to be printed.
 
 func (state State) String() string {
@@ -109,6 +147,25 @@ func (event AliveCellsCount) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (event TilesSkipped) String() string {
+	return fmt.Sprintf("Skipped %.1f%% of tiles", 100*event.Fraction)
+}
+
+func (event TilesSkipped) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
+func (event CycleDetected) String() string {
+	if event.Period == 1 {
+		return fmt.Sprintf("Still life from turn %v", event.Start)
+	}
+	return fmt.Sprintf("Repeating every %v turns from turn %v", event.Period, event.Start)
+}
+
+func (event CycleDetected) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
 func (event ImageOutputComplete) String() string {
 	return fmt.Sprintf("File %v Output Done", event.Filename)
 }
@@ -133,6 +190,14 @@ func (event CellsFlipped) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (event CellsShaded) String() string {
+	return ""
+}
+
+func (event CellsShaded) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
 func (event TurnComplete) String() string {
 	return ""
 }
@@ -141,6 +206,22 @@ func (event TurnComplete) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (event ErrorOccurred) String() string {
+	return fmt.Sprintf("Error: %v", event.Err)
+}
+
+func (event ErrorOccurred) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
+func (event Warning) String() string {
+	return fmt.Sprintf("Warning: %v", event.Message)
+}
+
+func (event Warning) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
 func (event FinalTurnComplete) String() string {
 	return "Final Turn Complete"
 }
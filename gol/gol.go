@@ -1,11 +1,160 @@
 package gol
 
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// DefaultServer is the broker address used when Params.Servers is empty.
+const DefaultServer = "127.0.0.1:8030"
+
+// DefaultTimeout is how long to wait for each server to answer when Params.Timeout is not set.
+const DefaultTimeout = 5 * time.Second
+
+// DefaultFrameRate is how many times a second StreamFrames mode asks for flipped cells when Params.FrameRate is not set.
+const DefaultFrameRate = 60
+
+// StreamMode chooses how the cells flipped on the server are sent back for the SDL window.
+type StreamMode int
+
+const (
+	// StreamTurns sends CellsFlipped and TurnComplete events for every turn. The server waits for the GUI to keep up.
+	StreamTurns StreamMode = iota
+	// StreamFrames sends at most FrameRate CellsFlipped and TurnComplete events a second, merging the turns in between.
+	StreamFrames
+	// StreamOff sends no CellsFlipped or TurnComplete events, so the server runs at full speed.
+	StreamOff
+)
+
+// wire returns the stubs stream mode matching m.
+func (m StreamMode) wire() int {
+	switch m {
+	case StreamTurns:
+		return stubs.StreamTurns
+	case StreamFrames:
+		return stubs.StreamFrames
+	default:
+		return stubs.StreamOff
+	}
+}
+
+// Format is the file format worlds are saved in under out/.
+type Format int
+
+const (
+	// FormatPGM saves a P5 PGM image, keeping the grey levels of dying cells.
+	FormatPGM Format = iota
+	// FormatRLE saves run length encoded cells with the rule and turn, as read by most Life programs.
+	FormatRLE
+	// FormatPlaintext saves rows of . and O, as in .cells files. Dying cells are saved as dead.
+	FormatPlaintext
+	// FormatMacrocell saves a quadtree with the rule and turn, as read by Golly, which suits large sparse worlds.
+	FormatMacrocell
+	// FormatPNG saves a PNG image drawn with Params.Scale and Params.Palette, for dropping into reports.
+	FormatPNG
+)
+
+var formatNames = []string{
+	FormatPGM:       "pgm",
+	FormatRLE:       "rle",
+	FormatPlaintext: "plaintext",
+	FormatMacrocell: "macrocell",
+	FormatPNG:       "png",
+}
+
+var formatExtensions = []string{
+	FormatPGM:       ".pgm",
+	FormatRLE:       ".rle",
+	FormatPlaintext: ".cells",
+	FormatMacrocell: ".mc",
+	FormatPNG:       ".png",
+}
+
+// ParseFormat reads a format by name, such as rle, or by the extension of a file name, such as .cells or glider.mc.
+func ParseFormat(s string) (Format, error) {
+	for f := range formatNames {
+		if strings.EqualFold(s, formatNames[f]) || strings.EqualFold(filepath.Ext(s), formatExtensions[f]) ||
+			strings.EqualFold("."+s, formatExtensions[f]) {
+			return Format(f), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown format %q, expected one of %v or a file name ending %v",
+		s, strings.Join(formatNames, ", "), strings.Join(formatExtensions, ", "))
+}
+
+func (f Format) String() string {
+	if f < 0 || int(f) >= len(formatNames) {
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+	return formatNames[f]
+}
+
+// Ext is the extension of files saved in f.
+func (f Format) Ext() string {
+	if f < 0 || int(f) >= len(formatExtensions) {
+		return ""
+	}
+	return formatExtensions[f]
+}
+
 // Params provides the details of how to run the Game of Life and which image to load.
 type Params struct {
 	Turns       int
 	Threads     int
 	ImageWidth  int
 	ImageHeight int
+	// Rule is the Life-like rule new sessions are run under. The zero Rule is Conway's Life.
+	Rule stubs.Rule
+	// Boundary decides what lies beyond the edges of the world in new sessions. The zero Boundary is a torus.
+	Boundary stubs.Boundary
+	// Engine picks how the server evolves new sessions. The zero Engine steps every cell every turn.
+	Engine stubs.Engine
+	// Cycles decides whether new sessions watch for the world repeating itself, and stop early when it does.
+	Cycles stubs.Cycles
+	// Image is the path of a PGM image to start new sessions from instead of images/<width>x<height>.pgm.
+	// Cells whose grey level, scaled to run up to 255, reaches Threshold are alive. The zero Threshold keeps
+	// the levels of images with a maxval of 255 as they are, so that dying cells saved under Generations rules
+	// are read back as they were, and puts it halfway for other images.
+	Image     string
+	Threshold int
+	// Fit crops Image, or pads it with dead cells, when it is not ImageWidth x ImageHeight, rather than failing.
+	// The image keeps its top left corner in the top left of the world.
+	Fit bool
+	// Pattern is the path of an RLE, Life 1.05, Life 1.06, plaintext or macrocell file to start new sessions from
+	// instead of images/<width>x<height>.pgm. The pattern is placed with its top left corner at PatternOffset,
+	// and any of it that falls beyond the edges of the world is dropped. A rule given in the file is used if Rule is not set.
+	Pattern       string
+	PatternOffset util.Cell
+	// Format is the file format worlds are saved in under out/. The zero Format is PGM.
+	Format Format
+	// Scale is how many pixels wide each cell is drawn in PNG and GIF output. The zero Scale draws one pixel a cell.
+	Scale int
+	// Palette colours the cells in PNG and GIF output.
+	Palette Palette
+	// AnimateFrom and AnimateTo are the first and last turns recorded as an animated GIF in
+	// out/<width>x<height>x<from>-<to>.gif, one frame a turn. The zero AnimateTo records no animation.
+	// Animating has the server stream every turn, however Stream is set.
+	AnimateFrom int
+	AnimateTo   int
+	// Session is the ID of a running session to reattach to instead of loading a new image.
+	Session string
+	// Resume restarts Session from its latest checkpoint on the server, for when the server has been restarted.
+	// With no Session, the most recently saved checkpoint is resumed.
+	Resume bool
+	// Owner names whoever starts a session, so that the server knows who may cancel it.
+	Owner string
+	// Servers lists the broker addresses to try in order, falling back to the next one if a server cannot be reached.
+	Servers []string
+	// Timeout is how long to wait for each server to answer. The zero Timeout is DefaultTimeout.
+	Timeout time.Duration
+	// Stream and FrameRate decide how often the SDL window is sent flipped cells.
+	Stream    StreamMode
+	FrameRate int
 }
 
 // Run starts the processing of Game of Life. It should initialise channels and goroutines.
@@ -17,14 +166,20 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 	ioIdle := make(chan bool)
 	ioFilename := make(chan string)
 	ioOutput := make(chan uint8)
+	ioTurn := make(chan int)
 	ioInput := make(chan uint8)
+	ioError := make(chan error)
+	ioRule := make(chan stubs.Rule)
 
 	ioChannels := ioChannels{
 		command:  ioCommand,
 		idle:     ioIdle,
 		filename: ioFilename,
 		output:   ioOutput,
+		turn:     ioTurn,
 		input:    ioInput,
+		err:      ioError,
+		rule:     ioRule,
 	}
 	go startIo(p, ioChannels)
 
@@ -34,7 +189,36 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 		ioIdle:     ioIdle,
 		ioFilename: ioFilename,
 		ioOutput:   ioOutput,
+		ioTurn:     ioTurn,
 		ioInput:    ioInput,
+		ioError:    ioError,
+		ioRule:     ioRule,
 	}
 	distributor(p, distributorChannels, keyPresses)
 }
+
+// SessionInfo describes a session running on the server.
+type SessionInfo = stubs.SessionInfo
+
+// Sessions lists the sessions on the first reachable server in p.Servers, oldest first.
+func Sessions(p Params) ([]SessionInfo, error) {
+	client, err := dial(p)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	res := new(stubs.ListResponse)
+	err = client.Call(stubs.ListHandler, stubs.ListRequest{}, res)
+	return res.Sessions, err
+}
+
+// Cancel stops session on the first reachable server in p.Servers and throws its world away.
+// It fails unless p.Owner started the session.
+func Cancel(p Params, session string) error {
+	client, err := dial(p)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Call(stubs.CancelHandler, stubs.ControlRequest{Session: session, Owner: p.Owner}, new(stubs.Response))
+}
@@ -0,0 +1,119 @@
+package gol
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// animationDelay is how long each frame of an animation is shown, in hundredths of a second.
+const animationDelay = 10
+
+// Palette colours the cells of PNG and GIF output. Cells dying under a Generations rule are shaded from Alive to Dead.
+// The zero Palette is PaletteGrey.
+type Palette struct {
+	Dead  color.RGBA
+	Alive color.RGBA
+}
+
+var (
+	// PaletteGrey draws live cells white on black, as in PGM images.
+	PaletteGrey = Palette{Dead: color.RGBA{0, 0, 0, 255}, Alive: color.RGBA{255, 255, 255, 255}}
+	// PaletteInverted draws live cells black on white, which suits printed reports.
+	PaletteInverted = Palette{Dead: color.RGBA{255, 255, 255, 255}, Alive: color.RGBA{0, 0, 0, 255}}
+	// PaletteGreen draws live cells green on black.
+	PaletteGreen = Palette{Dead: color.RGBA{0, 0, 0, 255}, Alive: color.RGBA{0, 255, 64, 255}}
+	// PaletteAmber draws live cells amber on dark brown.
+	PaletteAmber = Palette{Dead: color.RGBA{32, 16, 0, 255}, Alive: color.RGBA{255, 176, 0, 255}}
+)
+
+var paletteNames = []struct {
+	name    string
+	palette Palette
+}{
+	{"grey", PaletteGrey},
+	{"inverted", PaletteInverted},
+	{"green", PaletteGreen},
+	{"amber", PaletteAmber},
+}
+
+// ParsePalette reads a palette by name, such as inverted, or as the colours of dead and live cells, such as #ffffff,#0000ff.
+func ParsePalette(s string) (Palette, error) {
+	var names []string
+	for _, named := range paletteNames {
+		if strings.EqualFold(s, named.name) {
+			return named.palette, nil
+		}
+		names = append(names, named.name)
+	}
+	var pal Palette
+	_, err := fmt.Sscanf(strings.ToLower(s), "#%02x%02x%02x,#%02x%02x%02x",
+		&pal.Dead.R, &pal.Dead.G, &pal.Dead.B, &pal.Alive.R, &pal.Alive.G, &pal.Alive.B)
+	if err != nil {
+		return Palette{}, fmt.Errorf("unknown palette %q, expected one of %v or two colours such as #000000,#ffffff",
+			s, strings.Join(names, ", "))
+	}
+	pal.Dead.A, pal.Alive.A = 255, 255
+	return pal, nil
+}
+
+// OrGrey returns pal, or PaletteGrey if pal is the zero Palette.
+func (pal Palette) OrGrey() Palette {
+	if pal == (Palette{}) {
+		return PaletteGrey
+	}
+	return pal
+}
+
+func (pal Palette) String() string {
+	pal = pal.OrGrey()
+	for _, named := range paletteNames {
+		if pal == named.palette {
+			return named.name
+		}
+	}
+	return fmt.Sprintf("#%02x%02x%02x,#%02x%02x%02x", pal.Dead.R, pal.Dead.G, pal.Dead.B, pal.Alive.R, pal.Alive.G, pal.Alive.B)
+}
+
+// colours returns a colour for each grey level, so that the levels of the world can be used as indices into it.
+func (pal Palette) colours() color.Palette {
+	pal = pal.OrGrey()
+	blend := func(dead, alive uint8, level int) uint8 {
+		return uint8((int(dead)*(255-level) + int(alive)*level) / 255)
+	}
+	colours := make(color.Palette, 256)
+	for level := range colours {
+		colours[level] = color.RGBA{
+			R: blend(pal.Dead.R, pal.Alive.R, level),
+			G: blend(pal.Dead.G, pal.Alive.G, level),
+			B: blend(pal.Dead.B, pal.Alive.B, level),
+			A: 255,
+		}
+	}
+	return colours
+}
+
+// picture draws world with each cell as a square scale pixels wide, coloured from colours by its grey level.
+func picture(world [][]uint8, scale int, colours color.Palette) *image.Paletted {
+	if scale < 1 {
+		scale = 1
+	}
+	height := len(world)
+	width := 0
+	if height > 0 {
+		width = len(world[0])
+	}
+	img := image.NewPaletted(image.Rect(0, 0, width*scale, height*scale), colours)
+	for y, row := range world {
+		for dy := 0; dy < scale; dy++ {
+			line := img.Pix[(y*scale+dy)*img.Stride:]
+			for x, level := range row {
+				for dx := 0; dx < scale; dx++ {
+					line[x*scale+dx] = level
+				}
+			}
+		}
+	}
+	return img
+}
@@ -0,0 +1,202 @@
+package gol
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"testing"
+)
+
+// TestParsePalette tests that palettes are read by name whatever their case, or as two colours, and that
+// they are written back the same way.
+func TestParsePalette(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Palette
+		name string
+	}{
+		{"grey", PaletteGrey, "grey"},
+		{"Inverted", PaletteInverted, "inverted"},
+		{"GREEN", PaletteGreen, "green"},
+		{"amber", PaletteAmber, "amber"},
+		{"#102030,#A0B0C0", Palette{Dead: color.RGBA{16, 32, 48, 255}, Alive: color.RGBA{160, 176, 192, 255}}, "#102030,#a0b0c0"},
+		{"#ffffff,#000000", PaletteInverted, "inverted"},
+	}
+	for _, test := range tests {
+		pal, err := ParsePalette(test.s)
+		if err != nil {
+			t.Errorf("%q: %v", test.s, err)
+			continue
+		}
+		if pal != test.want {
+			t.Errorf("%q: read %+v, want %+v", test.s, pal, test.want)
+		}
+		if pal.String() != test.name {
+			t.Errorf("%q: written as %q, want %q", test.s, pal.String(), test.name)
+		}
+	}
+	if name := (Palette{}).String(); name != "grey" {
+		t.Errorf("zero palette written as %q, want grey", name)
+	}
+
+	for _, s := range []string{"", "purple", "grey,", "#ffffff", "#fff,#000", "#gggggg,#000000", "#ffffff;#000000", "ffffff,000000"} {
+		if pal, err := ParsePalette(s); err == nil {
+			t.Errorf("%q: read %+v, want an error", s, pal)
+		}
+	}
+}
+
+// inTempDir runs the rest of the test in an empty directory, so that files written to out/ are removed when it ends.
+func inTempDir(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+}
+
+// testIo is an io goroutine driven by a test in place of the distributor.
+type testIo struct {
+	command  chan ioCommand
+	idle     chan bool
+	filename chan string
+	turn     chan int
+	output   chan uint8
+}
+
+// startTestIo starts an io goroutine for p, stopped when the test ends.
+func startTestIo(t *testing.T, p Params) *testIo {
+	io := &testIo{make(chan ioCommand), make(chan bool), make(chan string), make(chan int), make(chan uint8)}
+	go startIo(p, ioChannels{command: io.command, idle: io.idle, filename: io.filename, turn: io.turn, output: io.output})
+	t.Cleanup(func() { close(io.command) })
+	return io
+}
+
+// wait waits for the io goroutine to finish what it was asked to do, such as writing a file.
+func (io *testIo) wait() {
+	io.command <- ioCheckIdle
+	<-io.idle
+}
+
+// sendWorld sends the cells of world to the io goroutine, row by row.
+func (io *testIo) sendWorld(world [][]uint8) {
+	for _, row := range world {
+		for _, cell := range row {
+			io.output <- cell
+		}
+	}
+}
+
+// checkPicture checks that img draws world with each cell scale pixels wide, in the colours of pal.
+func checkPicture(t *testing.T, name string, img image.Image, world [][]uint8, scale int, pal Palette) {
+	if size := img.Bounds().Size(); size.X != len(world[0])*scale || size.Y != len(world)*scale {
+		t.Errorf("%v: %vx%v pixels, want %vx%v", name, size.X, size.Y, len(world[0])*scale, len(world)*scale)
+		return
+	}
+	colours := pal.colours()
+	for y := 0; y < len(world)*scale; y++ {
+		for x := 0; x < len(world[0])*scale; x++ {
+			want := colours[world[y/scale][x/scale]]
+			if got := color.RGBAModel.Convert(img.At(x, y)); got != want {
+				t.Errorf("%v: pixel (%v, %v) is %v, want %v", name, x, y, got, want)
+				return
+			}
+		}
+	}
+}
+
+var pictureWorld = [][]uint8{
+	{0, 255, 0},
+	{255, 128, 0},
+}
+
+// TestWritePng tests that PNG output is scaled and coloured as asked.
+func TestWritePng(t *testing.T) {
+	inTempDir(t)
+	tests := []struct {
+		name    string
+		scale   int
+		palette Palette
+	}{
+		{"unscaled", 0, Palette{}},
+		{"scaled", 4, PaletteGrey},
+		{"scaled and amber", 3, PaletteAmber},
+	}
+	for _, test := range tests {
+		p := Params{ImageWidth: 3, ImageHeight: 2, Format: FormatPNG, Scale: test.scale, Palette: test.palette}
+		io := startTestIo(t, p)
+		io.command <- ioOutput
+		io.filename <- test.name
+		io.turn <- 1
+		io.sendWorld(pictureWorld)
+		io.wait()
+
+		file, err := os.Open("out/" + test.name + ".png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(file)
+		file.Close()
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		scale := test.scale
+		if scale == 0 {
+			scale = 1
+		}
+		checkPicture(t, test.name, img, pictureWorld, scale, test.palette)
+	}
+}
+
+// TestWriteAnimation tests that GIF output has a frame for each world recorded, scaled and coloured as asked,
+// and that each animation starts afresh.
+func TestWriteAnimation(t *testing.T) {
+	inTempDir(t)
+	p := Params{ImageWidth: 3, ImageHeight: 2, Scale: 2, Palette: PaletteGreen}
+	io := startTestIo(t, p)
+
+	frames := [][][]uint8{pictureWorld, {{0, 0, 255}, {255, 255, 0}}, {{0, 0, 0}, {0, 0, 0}}}
+	for _, frame := range frames {
+		io.command <- ioFrame
+		io.sendWorld(frame)
+	}
+	io.command <- ioAnimation
+	io.filename <- "first"
+	io.command <- ioFrame
+	io.sendWorld(frames[1])
+	io.command <- ioAnimation
+	io.filename <- "second"
+	io.wait()
+
+	for _, animation := range []struct {
+		name   string
+		frames [][][]uint8
+	}{{"first", frames}, {"second", frames[1:2]}} {
+		file, err := os.Open("out/" + animation.name + ".gif")
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, err := gif.DecodeAll(file)
+		file.Close()
+		if err != nil {
+			t.Errorf("%v: %v", animation.name, err)
+			continue
+		}
+		if len(g.Image) != len(animation.frames) {
+			t.Errorf("%v: %v frames, want %v", animation.name, len(g.Image), len(animation.frames))
+			continue
+		}
+		for i, img := range g.Image {
+			if g.Delay[i] != animationDelay {
+				t.Errorf("%v: frame %v shown for %v, want %v", animation.name, i, g.Delay[i], animationDelay)
+			}
+			checkPicture(t, animation.name, img, animation.frames[i], 2, PaletteGreen)
+		}
+	}
+}
@@ -1,10 +1,15 @@
 package gol
 
 import (
+	"errors"
 	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
 	"os"
 	"strconv"
-	"strings"
+	"uk.ac.bris.cs/gameoflife/stubs"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
@@ -15,12 +20,23 @@ type ioChannels struct {
 	filename <-chan string
 	output   <-chan uint8
 	input    chan<- uint8
+	// turn carries the turn a world being output was reached at, sent after its filename.
+	turn <-chan int
+	// err carries whether a file being input could be read, sent before its data.
+	err chan<- error
+	// rule carries the rule a pattern is to run under, sent after its cells.
+	rule chan<- stubs.Rule
 }
 
 // ioState is the internal ioState of the io goroutine.
 type ioState struct {
 	params   Params
 	channels ioChannels
+	// rule is the rule the world is run under, which may have come from the pattern it was read from.
+	rule stubs.Rule
+	// colours maps grey levels to the colours of params.Palette, and frames holds the animation recorded so far.
+	colours color.Palette
+	frames  []*image.Paletted
 }
 
 // ioCommand allows requesting behaviour from the io (pgm) goroutine.
@@ -31,18 +47,24 @@ type ioCommand uint8
 //		ioOutput 	= 0
 //		ioInput 	= 1
 //		ioCheckIdle = 2
+//		ioFrame 	= 3
+//		ioAnimation = 4
 const (
 	ioOutput ioCommand = iota
 	ioInput
 	ioCheckIdle
+	ioFrame
+	ioAnimation
 )
 
 // writePgmImage receives an array of bytes and writes it to a pgm file.
+// Cells that are dying under a Generations rule keep their grey level in the image.
 func (io *ioState) writePgmImage() {
 	_ = os.Mkdir("out", os.ModePerm)
 
-	// Request a filename from the distributor.
+	// Request a filename from the distributor. PGM files have nowhere to keep the turn.
 	filename := <-io.channels.filename
+	<-io.channels.turn
 
 	file, ioError := os.Create("out/" + filename + ".pgm")
 	util.Check(ioError)
@@ -85,43 +107,175 @@ func (io *ioState) writePgmImage() {
 	fmt.Println("File", filename, "output done!")
 }
 
-// readPgmImage opens a pgm file and sends its data as an array of bytes.
+// readPgmImage opens a pgm file and sends its data as an array of bytes,
+// after sending nil on the err channel, or the reason it could not be read instead.
 func (io *ioState) readPgmImage() {
 
-	// Request a filename from the distributor.
+	// Request a path from the distributor.
 	filename := <-io.channels.filename
 
-	data, ioError := os.ReadFile("images/" + filename + ".pgm")
-	util.Check(ioError)
+	img, ioError := readPGM(filename)
+	if ioError == nil && !io.params.Fit && (img.width != io.params.ImageWidth || img.height != io.params.ImageHeight) {
+		ioError = fmt.Errorf("%v is %vx%v, not %vx%v", filename, img.width, img.height, io.params.ImageWidth, io.params.ImageHeight)
+	}
+	io.channels.err <- ioError
+	if ioError != nil {
+		return
+	}
+
+	// Images that do not fit are cropped, or padded with dead cells, on the right and at the bottom.
+	world := img.world(io.params.Threshold)
+	for y := 0; y < io.params.ImageHeight; y++ {
+		for x := 0; x < io.params.ImageWidth; x++ {
+			var b uint8
+			if y < img.height && x < img.width {
+				b = world[y][x]
+			}
+			io.channels.input <- b
+		}
+	}
 
-	fields := strings.Fields(string(data))
+	fmt.Println("File", filename, "input done!")
+}
+
+// readPatternFile opens a pattern file and sends its data as an array of bytes, placed on a world of the size given
+// by io.params, followed by the rule the pattern is to run under. Like readPgmImage, it first sends the outcome of reading it.
+func (io *ioState) readPatternFile() {
+
+	// Request a path from the distributor.
+	filename := <-io.channels.filename
 
-	if fields[0] != "P5" {
-		panic("Not a pgm file")
+	pt, ioError := readPattern(filename)
+	io.channels.err <- ioError
+	if ioError != nil {
+		return
 	}
 
-	width, _ := strconv.Atoi(fields[1])
-	if width != io.params.ImageWidth {
-		panic("Incorrect width")
+	rule := io.params.Rule
+	if rule == (stubs.Rule{}) && pt.ruled {
+		rule = pt.rule
 	}
+	io.rule = rule
 
-	height, _ := strconv.Atoi(fields[2])
-	if height != io.params.ImageHeight {
-		panic("Incorrect height")
+	world := make([][]byte, io.params.ImageHeight)
+	for i := range world {
+		world[i] = make([]byte, io.params.ImageWidth)
+	}
+	for i, cell := range pt.cells {
+		x, y := cell.X+io.params.PatternOffset.X, cell.Y+io.params.PatternOffset.Y
+		if x >= 0 && x < io.params.ImageWidth && y >= 0 && y < io.params.ImageHeight {
+			world[y][x] = rule.OrLife().Level(pt.states[i])
+		}
 	}
 
-	maxval, _ := strconv.Atoi(fields[3])
-	if maxval != 255 {
-		panic("Incorrect maxval/bit depth")
+	for y := 0; y < io.params.ImageHeight; y++ {
+		for x := 0; x < io.params.ImageWidth; x++ {
+			io.channels.input <- world[y][x]
+		}
 	}
+	io.channels.rule <- rule
+
+	fmt.Println("File", filename, "input done!")
+}
 
-	image := []byte(fields[4])
+// writePatternImage receives an array of bytes and writes it to a pattern file in io.params.Format.
+func (io *ioState) writePatternImage() {
+	_ = os.Mkdir("out", os.ModePerm)
+
+	// Request a filename and the turn reached from the distributor.
+	filename := <-io.channels.filename
+	turn := <-io.channels.turn
+	world := io.receiveWorld()
 
-	for _, b := range image {
-		io.channels.input <- b
+	var data string
+	rule := io.rule.OrLife()
+	switch io.params.Format {
+	case FormatRLE:
+		data = encodeRLE(world, rule, filename, turn)
+	case FormatPlaintext:
+		data = encodePlaintext(world, rule, filename, turn)
+	case FormatMacrocell:
+		data = encodeMacrocell(world, rule, turn)
 	}
+	ioError := os.WriteFile("out/"+filename+io.params.Format.Ext(), []byte(data), 0644)
+	util.Check(ioError)
 
-	fmt.Println("File", filename, "input done!")
+	fmt.Println("File", filename, "output done!")
+}
+
+// receiveWorld receives the cells of a world being output from the distributor, row by row.
+func (io *ioState) receiveWorld() [][]uint8 {
+	world := make([][]uint8, io.params.ImageHeight)
+	for y := range world {
+		world[y] = make([]uint8, io.params.ImageWidth)
+		for x := range world[y] {
+			world[y][x] = <-io.channels.output
+		}
+	}
+	return world
+}
+
+// writePngImage receives an array of bytes and writes it to a png file, drawn with io.params.Scale and io.params.Palette.
+func (io *ioState) writePngImage() {
+	_ = os.Mkdir("out", os.ModePerm)
+
+	// Request a filename from the distributor. The turn is not drawn.
+	filename := <-io.channels.filename
+	<-io.channels.turn
+	world := io.receiveWorld()
+
+	file, ioError := os.Create("out/" + filename + ".png")
+	util.Check(ioError)
+	defer file.Close()
+	ioError = png.Encode(file, picture(world, io.params.Scale, io.colours))
+	util.Check(ioError)
+
+	fmt.Println("File", filename, "output done!")
+}
+
+// recordFrame receives an array of bytes and keeps it as the next frame of the animation.
+func (io *ioState) recordFrame() {
+	io.frames = append(io.frames, picture(io.receiveWorld(), io.params.Scale, io.colours))
+}
+
+// writeAnimation writes the frames recorded so far to a gif file and starts a new animation.
+func (io *ioState) writeAnimation() {
+	_ = os.Mkdir("out", os.ModePerm)
+
+	filename := <-io.channels.filename
+	delays := make([]int, len(io.frames))
+	for i := range delays {
+		delays[i] = animationDelay
+	}
+	file, ioError := os.Create("out/" + filename + ".gif")
+	util.Check(ioError)
+	defer file.Close()
+	ioError = gif.EncodeAll(file, &gif.GIF{Image: io.frames, Delay: delays})
+	util.Check(ioError)
+	io.frames = nil
+
+	fmt.Println("File", filename, "output done!")
+}
+
+// InputSize reads the width and height of a PGM image or pattern file, reporting whether it is an image.
+// Patterns are the size their file gives them, or as large as their cells reach if that is larger.
+func InputSize(path string) (width, height int, image bool, err error) {
+	img, err := readPGM(path)
+	if err == nil {
+		return img.width, img.height, true, nil
+	}
+	if !errors.Is(err, errNotPGM) {
+		return 0, 0, false, err
+	}
+	pt, err := readPattern(path)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	width, height = pt.size()
+	if width == 0 || height == 0 {
+		return 0, 0, false, fmt.Errorf("%v: pattern is empty", path)
+	}
+	return width, height, false, nil
 }
 
 // startIo should be the entrypoint of the io goroutine.
@@ -129,17 +283,34 @@ func startIo(p Params, c ioChannels) {
 	io := ioState{
 		params:   p,
 		channels: c,
+		rule:     p.Rule,
+		colours:  p.Palette.colours(),
 	}
 
 	for command := range io.channels.command {
 		// Block and wait for requests from the distributor
 		switch command {
 		case ioInput:
-			io.readPgmImage()
+			if io.params.Pattern != "" {
+				io.readPatternFile()
+			} else {
+				io.readPgmImage()
+			}
 		case ioOutput:
-			io.writePgmImage()
+			switch io.params.Format {
+			case FormatPGM:
+				io.writePgmImage()
+			case FormatPNG:
+				io.writePngImage()
+			default:
+				io.writePatternImage()
+			}
 		case ioCheckIdle:
 			io.channels.idle <- true
+		case ioFrame:
+			io.recordFrame()
+		case ioAnimation:
+			io.writeAnimation()
 		}
 	}
 }
@@ -0,0 +1,125 @@
+package gol
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFile writes data to a file called name in a directory that is removed when the test ends, returning its path.
+func writeFile(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readInput has an io goroutine read the world p starts from, as the distributor does.
+func readInput(p Params, path string) ([][]uint8, error) {
+	command := make(chan ioCommand)
+	filename := make(chan string)
+	input := make(chan uint8)
+	errs := make(chan error)
+	go startIo(p, ioChannels{command: command, filename: filename, input: input, err: errs})
+	defer close(command)
+
+	command <- ioInput
+	filename <- path
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	world := make([][]uint8, p.ImageHeight)
+	for y := range world {
+		world[y] = make([]uint8, p.ImageWidth)
+		for x := range world[y] {
+			world[y][x] = <-input
+		}
+	}
+	return world, nil
+}
+
+// TestInputSize tests that images and patterns are sized from their files, and that files which cannot be read
+// or hold no cells are refused.
+func TestInputSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		data   string
+		width  int
+		height int
+		image  bool
+	}{
+		{"binary pgm", "world.pgm", "P5 7 5 255\n" + string(make([]byte, 35)), 7, 5, true},
+		{"plain pgm with another extension", "world.txt", "P2 2 3 1\n0 1\n1 0\n0 0\n", 2, 3, true},
+		{"rle", "glider.rle", "x = 3, y = 3\nbo$2bo$3o!", 3, 3, false},
+		{"rle larger than its cells", "glider.rle", "x = 10, y = 8\nbo$2bo$3o!", 10, 8, false},
+		{"plaintext", "glider.cells", "!Name: glider\n.O\n..O\nOOO\n", 3, 3, false},
+		{"life 1.06", "glider.lif", "#Life 1.06\n1 0\n2 1\n0 2\n1 2\n2 2\n", 3, 3, false},
+		{"macrocell", "world.mc", "[M2]\n#C size 20x12\n$.*$\n4 1 0 0 0\n", 20, 12, false},
+	}
+	for _, test := range tests {
+		width, height, image, err := InputSize(writeFile(t, test.file, test.data))
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if width != test.width || height != test.height || image != test.image {
+			t.Errorf("%v: %vx%v with image %v, want %vx%v with image %v", test.name, width, height, image, test.width, test.height, test.image)
+		}
+	}
+
+	bad := []struct {
+		name string
+		file string
+		data string
+	}{
+		{"empty rle", "empty.rle", "x = 0, y = 0\n!"},
+		{"rle with no cells", "empty.rle", "#N nothing\n!"},
+		{"truncated pgm", "world.pgm", "P5 7 5 255\n\x00"},
+		{"broken pattern", "glider.rle", "x = 3, y = 3\nbo$2bz$3o!"},
+	}
+	for _, test := range bad {
+		if width, height, image, err := InputSize(writeFile(t, test.file, test.data)); err == nil {
+			t.Errorf("%v: %vx%v with image %v, want an error", test.name, width, height, image)
+		}
+	}
+	if _, _, _, err := InputSize(filepath.Join(t.TempDir(), "missing.pgm")); err == nil {
+		t.Errorf("missing file: want an error")
+	}
+}
+
+// TestFit tests that images which are not the size of the world are cropped or padded at the right and bottom
+// when Fit is set, and refused otherwise.
+func TestFit(t *testing.T) {
+	// A 4x3 image with a different cell alive in each row.
+	path := writeFile(t, "world.pgm", "P2 4 3 255\n255 0 0 0\n0 255 0 255\n0 0 0 255\n")
+	tests := []struct {
+		name   string
+		width  int
+		height int
+		want   [][]uint8
+	}{
+		{"same size", 4, 3, [][]uint8{{255, 0, 0, 0}, {0, 255, 0, 255}, {0, 0, 0, 255}}},
+		{"padded", 6, 4, [][]uint8{{255, 0, 0, 0, 0, 0}, {0, 255, 0, 255, 0, 0}, {0, 0, 0, 255, 0, 0}, {0, 0, 0, 0, 0, 0}}},
+		{"cropped", 2, 2, [][]uint8{{255, 0}, {0, 255}}},
+		{"cropped across and padded down", 3, 5, [][]uint8{{255, 0, 0}, {0, 255, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}}},
+	}
+	for _, test := range tests {
+		p := Params{ImageWidth: test.width, ImageHeight: test.height, Image: path, Fit: true}
+		world, err := readInput(p, path)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if fmt.Sprint(world) != fmt.Sprint(test.want) {
+			t.Errorf("%v: read %v, want %v", test.name, world, test.want)
+		}
+
+		p.Fit = false
+		if _, err := readInput(p, path); (err == nil) != (test.width == 4 && test.height == 3) {
+			t.Errorf("%v: error %v without Fit", test.name, err)
+		}
+	}
+}
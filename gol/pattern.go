@@ -0,0 +1,628 @@
+package gol
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// maxPatternCells is the most alive cells a pattern file may hold. RLE and macrocell files can describe far more
+// cells than they take up, and are refused rather than run out of memory expanding them.
+const maxPatternCells = 1 << 24
+
+// pattern is a set of cells read from a pattern file, relative to the top left corner of the pattern.
+type pattern struct {
+	// width and height are the size the file gives the pattern, if it gives one.
+	width  int
+	height int
+	cells  []util.Cell
+	// states holds the state of each of cells: 1 for alive, or above for dying under a Generations rule.
+	states []int
+	// rule is the rule the file says the pattern runs under, if ruled is set.
+	rule  stubs.Rule
+	ruled bool
+}
+
+func (pt *pattern) add(x, y, state int) {
+	pt.cells = append(pt.cells, util.Cell{X: x, Y: y})
+	pt.states = append(pt.states, state)
+}
+
+// size returns how wide and tall the pattern is: as its file says, or as far as its cells reach if that is further.
+func (pt *pattern) size() (width, height int) {
+	width, height = pt.width, pt.height
+	for _, cell := range pt.cells {
+		if cell.X >= width {
+			width = cell.X + 1
+		}
+		if cell.Y >= height {
+			height = cell.Y + 1
+		}
+	}
+	return width, height
+}
+
+// normalise moves the cells of a pattern given in absolute coordinates so that the leftmost and topmost cells
+// lie along the left and top edges of the pattern.
+func (pt *pattern) normalise() {
+	if len(pt.cells) == 0 {
+		return
+	}
+	minX, minY := pt.cells[0].X, pt.cells[0].Y
+	for _, cell := range pt.cells {
+		if cell.X < minX {
+			minX = cell.X
+		}
+		if cell.Y < minY {
+			minY = cell.Y
+		}
+	}
+	for i := range pt.cells {
+		pt.cells[i].X -= minX
+		pt.cells[i].Y -= minY
+	}
+}
+
+// setRule records the rule named in a pattern file. Golly's suffixes for bounded grids, such as ":T64,64", are dropped.
+func (pt *pattern) setRule(s string) error {
+	if i := strings.Index(s, ":"); i >= 0 {
+		s = s[:i]
+	}
+	rule, err := stubs.ParseRule(strings.TrimSpace(s))
+	if err != nil {
+		return err
+	}
+	pt.rule, pt.ruled = rule, true
+	return nil
+}
+
+// readPattern reads a pattern from an RLE, Life 1.05, Life 1.06, plaintext or macrocell file.
+func readPattern(path string) (*pattern, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pt, err := parsePattern(filepath.Ext(path), string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return pt, nil
+}
+
+// parsePattern tells the format of a pattern file from its first lines or, failing that, from its extension, and parses it.
+func parsePattern(ext, data string) (*pattern, error) {
+	lines := strings.Split(strings.ReplaceAll(data, "\r", ""), "\n")
+	first := ""
+	for _, line := range lines {
+		if first = strings.TrimSpace(line); first != "" {
+			break
+		}
+	}
+	switch {
+	case strings.HasPrefix(first, "[M2]"):
+		return parseMacrocell(lines)
+	case strings.HasPrefix(first, "#Life 1.05"):
+		return parseLife105(lines)
+	case strings.HasPrefix(first, "#Life 1.06"):
+		return parseLife106(lines)
+	}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "x ") || strings.HasPrefix(line, "x=") {
+			return parseRLE(lines)
+		}
+		break
+	}
+	switch strings.ToLower(ext) {
+	case ".rle":
+		return parseRLE(lines)
+	case ".lif", ".life":
+		return parseLife106(lines)
+	case ".mc":
+		return parseMacrocell(lines)
+	case ".cells", ".txt", "":
+		return parsePlaintext(lines)
+	}
+	return nil, errors.New("not a pattern file in a known format")
+}
+
+// parseRLE reads run length encoded cells, as in "bo$2bo$3o!", after an optional header such as "x = 3, y = 3, rule = B3/S23".
+// Generations states are written as letters from A, for alive, with p to y in front of them for states above 24.
+func parseRLE(lines []string) (*pattern, error) {
+	pt := new(pattern)
+	var body strings.Builder
+	header := true
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#r "):
+			if err := pt.setRule(line[3:]); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(line, "#"):
+		case header && (strings.HasPrefix(line, "x ") || strings.HasPrefix(line, "x=")):
+			// The rule is the last field and may itself hold commas.
+			if i := strings.Index(line, "rule"); i >= 0 {
+				rule := strings.TrimSpace(line[i+len("rule"):])
+				if err := pt.setRule(strings.TrimPrefix(rule, "=")); err != nil {
+					return nil, err
+				}
+				line = line[:i]
+			}
+			for _, field := range strings.Split(line, ",") {
+				key, value := field, ""
+				if i := strings.Index(field, "="); i >= 0 {
+					key, value = field[:i], strings.TrimSpace(field[i+1:])
+				}
+				var err error
+				switch strings.TrimSpace(key) {
+				case "x":
+					pt.width, err = strconv.Atoi(value)
+				case "y":
+					pt.height, err = strconv.Atoi(value)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("RLE header %q: %v", line, err)
+				}
+			}
+			header = false
+		default:
+			header = false
+			body.WriteString(line)
+		}
+	}
+
+	x, y, count, prefix := 0, 0, 0, 0
+	for _, c := range body.String() {
+		switch {
+		case c >= '0' && c <= '9':
+			count = count*10 + int(c-'0')
+			continue
+		case c == ' ' || c == '\t':
+			continue
+		case c == '!':
+			return pt, nil
+		case c >= 'p' && c <= 'y':
+			prefix = int(c-'p'+1) * 24
+			continue
+		}
+		if count == 0 {
+			count = 1
+		}
+		switch {
+		case c == '$':
+			x, y = 0, y+count
+		case c == 'b' || c == '.':
+			x += count
+		case c == 'o' || c >= 'A' && c <= 'X':
+			if len(pt.cells)+count > maxPatternCells {
+				return nil, fmt.Errorf("pattern has more than %v alive cells", maxPatternCells)
+			}
+			state := 1
+			if c != 'o' {
+				state = prefix + int(c-'A') + 1
+			}
+			for i := 0; i < count; i++ {
+				pt.add(x+i, y, state)
+			}
+			x += count
+		default:
+			return nil, fmt.Errorf("unexpected %q in RLE", c)
+		}
+		count, prefix = 0, 0
+	}
+	return pt, nil
+}
+
+// parseLife105 reads blocks of cells drawn with . and *, each starting at the position given by the "#P x y" line before it.
+// The rule is given by "#R" in S/B notation, or "#N" for Life.
+func parseLife105(lines []string) (*pattern, error) {
+	pt := new(pattern)
+	x, y := 0, 0
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#P"):
+			fields := strings.Fields(line[2:])
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("block position %q is not of the form #P x y", line)
+			}
+			var err error
+			if x, err = strconv.Atoi(fields[0]); err != nil {
+				return nil, err
+			}
+			if y, err = strconv.Atoi(fields[1]); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(line, "#R"):
+			if err := pt.setRule(line[2:]); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(line, "#N"):
+			pt.rule, pt.ruled = stubs.Life, true
+		case strings.HasPrefix(line, "#"):
+		default:
+			for i, c := range line {
+				switch c {
+				case '*':
+					pt.add(x+i, y, 1)
+				case '.':
+				default:
+					return nil, fmt.Errorf("unexpected %q in Life 1.05 block", c)
+				}
+			}
+			y++
+		}
+	}
+	pt.normalise()
+	return pt, nil
+}
+
+// parseLife106 reads the coordinates of one alive cell per line.
+func parseLife106(lines []string) (*pattern, error) {
+	pt := new(pattern)
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("cell %q is not of the form x y", line)
+		}
+		x, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		y, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		pt.add(x, y, 1)
+	}
+	pt.normalise()
+	return pt, nil
+}
+
+// parsePlaintext reads rows of cells drawn with . for dead and O or * for alive. Lines starting with ! are comments.
+func parsePlaintext(lines []string) (*pattern, error) {
+	pt := new(pattern)
+	y := 0
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		if strings.HasPrefix(line, "!") {
+			continue
+		}
+		for x, c := range line {
+			switch c {
+			case 'O', '*':
+				pt.add(x, y, 1)
+			case '.':
+			default:
+				return nil, fmt.Errorf("unexpected %q in plaintext pattern", c)
+			}
+		}
+		y++
+		// Dead cells drawn at the ends of rows and on rows at the bottom still count towards the size of the pattern.
+		if line != "" {
+			pt.height = y
+		}
+		if len(line) > pt.width {
+			pt.width = len(line)
+		}
+	}
+	return pt, nil
+}
+
+// macrocellNode is a square of cells in a macrocell file, 2^level across. Level 3 nodes are listed cell by cell,
+// and the others by the line numbers of their four quadrants, or by their states at level 1 for Generations rules.
+type macrocellNode struct {
+	level     int
+	quadrants [4]int
+	cells     []util.Cell
+}
+
+// parseMacrocell reads the quadtree of a macrocell file, as written by Golly, whose last node is the whole pattern.
+// Golly centres the tree on the origin, so the pattern is moved to the top left corner unless the file gives
+// the size of the world it was saved from in a "#C size" comment, as files written here do.
+func parseMacrocell(lines []string) (*pattern, error) {
+	pt := new(pattern)
+	sized := false
+	// Node 0 stands for an empty square of any size.
+	nodes := []macrocellNode{{}}
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#R"):
+			if err := pt.setRule(line[2:]); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(line, "#C size "):
+			if _, err := fmt.Sscanf(line, "#C size %dx%d", &pt.width, &pt.height); err != nil || pt.width < 1 || pt.height < 1 {
+				return nil, fmt.Errorf("world size %q is not of the form #C size 512x512", line)
+			}
+			sized = true
+		case strings.HasPrefix(line, "#"):
+		case strings.ContainsAny(line[:1], ".*$"):
+			node := macrocellNode{level: 3}
+			x, y := 0, 0
+			for _, c := range line {
+				switch c {
+				case '*':
+					if x > 7 || y > 7 {
+						return nil, fmt.Errorf("macrocell leaf %q is larger than 8x8", line)
+					}
+					node.cells = append(node.cells, util.Cell{X: x, Y: y})
+					x++
+				case '.':
+					x++
+				case '$':
+					x, y = 0, y+1
+				default:
+					return nil, fmt.Errorf("unexpected %q in macrocell leaf", c)
+				}
+			}
+			nodes = append(nodes, node)
+		default:
+			fields := strings.Fields(line)
+			if len(fields) != 5 {
+				return nil, fmt.Errorf("node %q is not of the form level nw ne sw se", line)
+			}
+			var numbers [5]int
+			for i, field := range fields {
+				n, err := strconv.Atoi(field)
+				if err != nil {
+					return nil, err
+				}
+				numbers[i] = n
+			}
+			node := macrocellNode{level: numbers[0]}
+			copy(node.quadrants[:], numbers[1:])
+			if node.level < 1 || node.level > 62 {
+				return nil, fmt.Errorf("node %q has level %v", line, node.level)
+			}
+			for _, quadrant := range node.quadrants {
+				switch {
+				case node.level == 1 && (quadrant < 0 || quadrant > 255):
+					return nil, fmt.Errorf("node %q has a cell in state %v", line, quadrant)
+				case node.level == 1, quadrant == 0:
+				case quadrant < 0 || quadrant >= len(nodes):
+					return nil, fmt.Errorf("node %q refers to node %v, which is not an earlier node", line, quadrant)
+				case nodes[quadrant].level != node.level-1:
+					return nil, fmt.Errorf("node %q of level %v refers to node %v of level %v", line, node.level, quadrant, nodes[quadrant].level)
+				}
+			}
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 1 {
+		return pt, nil
+	}
+
+	// Quadrants may be shared, so a short file can describe a vast pattern. Its cells are counted node by node
+	// before any of them are listed.
+	population := make([]int, len(nodes))
+	for i, node := range nodes {
+		switch {
+		case i == 0:
+		case node.level == 3 && node.cells != nil:
+			population[i] = len(node.cells)
+		case node.level == 1:
+			for _, state := range node.quadrants {
+				if state != 0 {
+					population[i]++
+				}
+			}
+		default:
+			for _, quadrant := range node.quadrants {
+				population[i] += population[quadrant]
+			}
+			if population[i] > maxPatternCells {
+				population[i] = maxPatternCells + 1
+			}
+		}
+	}
+	if population[len(nodes)-1] > maxPatternCells {
+		return nil, fmt.Errorf("pattern has more than %v alive cells", maxPatternCells)
+	}
+
+	var walk func(i, x, y int)
+	walk = func(i, x, y int) {
+		node := nodes[i]
+		switch {
+		case i == 0:
+		case node.level == 3 && node.cells != nil:
+			for _, cell := range node.cells {
+				pt.add(x+cell.X, y+cell.Y, 1)
+			}
+		case node.level == 1:
+			for q, state := range node.quadrants {
+				if state != 0 {
+					pt.add(x+q%2, y+q/2, state)
+				}
+			}
+		default:
+			half := 1 << (node.level - 1)
+			for q, quadrant := range node.quadrants {
+				walk(quadrant, x+q%2*half, y+q/2*half)
+			}
+		}
+	}
+	walk(len(nodes)-1, 0, 0)
+	if !sized {
+		pt.normalise()
+	}
+	return pt, nil
+}
+
+// rleLineLength is how long the lines of cells in RLE files written here are kept to, as most Life programs expect.
+const rleLineLength = 70
+
+// stateTable maps the grey level of each cell under rule to its state: 0 for dead, 1 for alive, and above for dying.
+func stateTable(rule stubs.Rule) [256]int {
+	var states [256]int
+	states[255] = 1
+	for k := 2; k < rule.States; k++ {
+		states[rule.Level(k)] = k
+	}
+	return states
+}
+
+// rleTag writes state k in RLE: b and o for rules with two states, or . and letters from A for Generations rules.
+func rleTag(k int, generations bool) string {
+	switch {
+	case !generations && k == 0:
+		return "b"
+	case !generations:
+		return "o"
+	case k == 0:
+		return "."
+	case k <= 24:
+		return string(rune('A' + k - 1))
+	default:
+		return string(rune('p'+(k-1)/24-1)) + string(rune('A'+(k-1)%24))
+	}
+}
+
+// encodeRLE writes world in RLE, naming it and noting the rule and the turn it was reached at in comments.
+// The turn is also given in the #CXRLE line Golly reads it from.
+func encodeRLE(world [][]uint8, rule stubs.Rule, name string, turn int) string {
+	states := stateTable(rule)
+	var b strings.Builder
+	fmt.Fprintf(&b, "#N %v\n#C Generation %v under rule %v\n#CXRLE Pos=0,0 Gen=%v\n", name, turn, rule, turn)
+	fmt.Fprintf(&b, "x = %v, y = %v, rule = %v\n", len(world[0]), len(world), rule)
+
+	length := 0
+	emit := func(count int, tag string) {
+		run := tag
+		if count > 1 {
+			run = strconv.Itoa(count) + tag
+		}
+		if length+len(run) > rleLineLength {
+			b.WriteString("\n")
+			length = 0
+		}
+		b.WriteString(run)
+		length += len(run)
+	}
+	// Dead cells at the ends of rows and empty rows at the bottom are left out, and empty rows
+	// in between are folded into the count of row ends.
+	rowEnds := 0
+	for _, row := range world {
+		end := len(row)
+		for end > 0 && states[row[end-1]] == 0 {
+			end--
+		}
+		if end > 0 && rowEnds > 0 {
+			emit(rowEnds, "$")
+			rowEnds = 0
+		}
+		for x := 0; x < end; {
+			count := 1
+			for x+count < end && states[row[x+count]] == states[row[x]] {
+				count++
+			}
+			emit(count, rleTag(states[row[x]], rule.Generations()))
+			x += count
+		}
+		rowEnds++
+	}
+	emit(1, "!")
+	b.WriteString("\n")
+	return b.String()
+}
+
+// encodePlaintext writes world as rows of . and O, naming it and noting the rule and turn in comments.
+func encodePlaintext(world [][]uint8, rule stubs.Rule, name string, turn int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "!Name: %v\n!Generation %v under rule %v\n", name, turn, rule)
+	for _, row := range world {
+		for _, cell := range row {
+			if cell == 255 {
+				b.WriteByte('O')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// encodeMacrocell writes world as a macrocell quadtree with the rule and turn, listing each distinct node once,
+// after the nodes it is made of. Rules with two states use 8x8 leaves, and Generations rules list the state of
+// every cell in 2x2 nodes. The tree starts at the top left corner of the world, whose size is given in a
+// "#C size" comment so that the world is read back where it was.
+func encodeMacrocell(world [][]uint8, rule stubs.Rule, turn int) string {
+	states := stateTable(rule)
+	height, width := len(world), len(world[0])
+	var b strings.Builder
+	fmt.Fprintf(&b, "[M2] (gameoflife)\n#R %v\n#G %v\n#C size %vx%v\n", rule, turn, width, height)
+
+	state := func(x, y int) int {
+		if x >= width || y >= height {
+			return 0
+		}
+		return states[world[y][x]]
+	}
+	// Nodes are numbered from 1 in the order they are written. Node 0 is an empty square of any size.
+	nodes := make(map[string]int)
+	intern := func(line string) int {
+		if n, ok := nodes[line]; ok {
+			return n
+		}
+		nodes[line] = len(nodes) + 1
+		b.WriteString(line + "\n")
+		return len(nodes)
+	}
+	var node func(level, x, y int) int
+	node = func(level, x, y int) int {
+		if level == 3 && !rule.Generations() {
+			var leaf strings.Builder
+			empty := true
+			for dy := 0; dy < 8; dy++ {
+				row := ""
+				for dx := 0; dx < 8; dx++ {
+					if state(x+dx, y+dy) == 1 {
+						row += strings.Repeat(".", dx-len(row)) + "*"
+						empty = false
+					}
+				}
+				leaf.WriteString(row + "$")
+			}
+			if empty {
+				return 0
+			}
+			return intern(leaf.String())
+		}
+		var quadrants [4]int
+		half := 1 << (level - 1)
+		for q := range quadrants {
+			if level == 1 {
+				quadrants[q] = state(x+q%2, y+q/2)
+			} else {
+				quadrants[q] = node(level-1, x+q%2*half, y+q/2*half)
+			}
+		}
+		if quadrants == [4]int{} {
+			return 0
+		}
+		return intern(fmt.Sprintf("%v %v %v %v %v", level, quadrants[0], quadrants[1], quadrants[2], quadrants[3]))
+	}
+
+	level := 3
+	for 1<<level < width || 1<<level < height {
+		level++
+	}
+	node(level, 0, 0)
+	return b.String()
+}
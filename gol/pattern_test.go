@@ -0,0 +1,231 @@
+package gol
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// glider is a glider in each of the pattern formats, starting in the top left corner.
+var glider = []util.Cell{{X: 1, Y: 0}, {X: 2, Y: 1}, {X: 0, Y: 2}, {X: 1, Y: 2}, {X: 2, Y: 2}}
+
+// cellString lists cells in row order, so that patterns can be compared whatever order their cells were read in.
+func cellString(cells []util.Cell) string {
+	sorted := append([]util.Cell(nil), cells...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Y != sorted[j].Y {
+			return sorted[i].Y < sorted[j].Y
+		}
+		return sorted[i].X < sorted[j].X
+	})
+	return fmt.Sprint(sorted)
+}
+
+// TestParsePattern tests reading a glider from each pattern format, with the size and rule the file gives it.
+func TestParsePattern(t *testing.T) {
+	tests := []struct {
+		name   string
+		ext    string
+		data   string
+		width  int
+		height int
+		rule   string
+	}{
+		{"rle", ".rle", "#N Glider\nx = 3, y = 3, rule = B3/S23\nbo$2bo$3o!\n", 3, 3, "B3/S23"},
+		{"rle without extension", "", "x = 5, y = 4, rule = B36/S23:T10,10\nbo$2bo$3o!", 5, 4, "B36/S23"},
+		{"rle with #r", ".rle", "#r 23/36\nx = 3, y = 3\nbo$\n2bo$3o!", 3, 3, "B36/S23"},
+		{"rle with carriage returns", ".rle", "x = 3, y = 3\r\nb\r\no$2bo$3o!\r\n", 3, 3, ""},
+		{"life 1.05", ".lif", "#Life 1.05\n#N\n#P -1 -1\n.*\n..*\n***\n", 3, 3, "B3/S23"},
+		{"life 1.05 in blocks", ".lif", "#Life 1.05\n#R 23/3\n#P 10 10\n.*\n#P 10 11\n..*\n***\n", 3, 3, "B3/S23"},
+		{"life 1.06", ".lif", "#Life 1.06\n0 -1\n1 0\n-1 1\n0 1\n1 1\n", 3, 3, ""},
+		{"life 1.06 by extension", ".life", "# glider\n5 4\n6 5\n4 6\n5 6\n6 6\n", 3, 3, ""},
+		{"plaintext", ".cells", "!Name: Glider\n.O\n..O\nOOO\n", 3, 3, ""},
+		{"plaintext with dead edges", ".cells", "!Name: Glider\n.O...\n..O\nOOO\n.\n", 5, 4, ""},
+		{"plaintext with stars", ".txt", ".*\n..*\n***", 3, 3, ""},
+		{"macrocell", ".mc", "[M2] (golly 4.0)\n#R B3/S23\n$.*$..*$***$\n4 1 0 0 0\n", 3, 3, "B3/S23"},
+		{"macrocell of level 1 nodes", ".mc", "[M2]\n#R B2/S/3\n1 0 1 0 0\n1 0 0 1 0\n1 1 1 0 0\n1 1 0 0 0\n2 1 2 3 4\n", 3, 3, "B2/S/3"},
+	}
+	for _, test := range tests {
+		pt, err := parsePattern(test.ext, test.data)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if got, want := cellString(pt.cells), cellString(glider); got != want {
+			t.Errorf("%v: cells %v, want %v", test.name, got, want)
+		}
+		if width, height := pt.size(); width != test.width || height != test.height {
+			t.Errorf("%v: size %vx%v, want %vx%v", test.name, width, height, test.width, test.height)
+		}
+		if rule := ""; pt.ruled && pt.rule.String() != test.rule || !pt.ruled && test.rule != rule {
+			t.Errorf("%v: rule %v (given %v), want %q", test.name, pt.rule, pt.ruled, test.rule)
+		}
+	}
+}
+
+// TestParsePatternErrors tests that malformed pattern files are rejected rather than read as something else.
+func TestParsePatternErrors(t *testing.T) {
+	// huge is a full leaf repeated in every quadrant of a tree 40 levels high, which would hold 2^80 cells.
+	huge := "[M2]\n" + strings.Repeat("********$", 8) + "\n"
+	for level := 4; level <= 40; level++ {
+		huge += fmt.Sprintf("%v %v %v %v %v\n", level, level-3, level-3, level-3, level-3)
+	}
+
+	tests := []struct {
+		name string
+		ext  string
+		data string
+	}{
+		{"rle with a bad cell", ".rle", "x = 3, y = 3\nbo$2bz$3o!"},
+		{"rle with a bad size", ".rle", "x = three, y = 3\nbo$2bo$3o!"},
+		{"rle with a bad rule", ".rle", "x = 3, y = 3, rule = B9/S23\nbo$2bo$3o!"},
+		{"life 1.05 with a bad position", ".lif", "#Life 1.05\n#P 1\n.*\n"},
+		{"life 1.05 with a bad cell", ".lif", "#Life 1.05\n#P 0 0\n.O\n"},
+		{"life 1.06 with three numbers", ".lif", "#Life 1.06\n0 1 2\n"},
+		{"life 1.06 with a word", ".lif", "#Life 1.06\n0 one\n"},
+		{"plaintext with a bad cell", ".cells", ".O\n..X\n"},
+		{"unknown extension", ".png", "\x89PNG"},
+		{"macrocell with a bad leaf", ".mc", "[M2]\n$.o$\n4 1 0 0 0\n"},
+		{"macrocell with a leaf too wide", ".mc", "[M2]\n.........*$\n4 1 0 0 0\n"},
+		{"macrocell with a leaf too tall", ".mc", "[M2]\n$$$$$$$$*$\n4 1 0 0 0\n"},
+		{"macrocell with too few quadrants", ".mc", "[M2]\n$.*$\n4 1 0 0\n"},
+		{"macrocell with a bad level", ".mc", "[M2]\n$.*$\n63 1 0 0 0\n"},
+		{"macrocell with a negative quadrant", ".mc", "[M2]\n4 -1 0 0 0\n"},
+		{"macrocell with a later quadrant", ".mc", "[M2]\n$.*$\n4 1 0 0 3\n"},
+		{"macrocell with a quadrant of the wrong level", ".mc", "[M2]\n$.*$\n5 1 0 0 0\n"},
+		{"macrocell with a quadrant of a level too low", ".mc", "[M2]\n1 1 0 0 0\n3 1 0 0 0\n"},
+		{"macrocell with a negative state", ".mc", "[M2]\n1 -1 0 0 0\n2 1 0 0 0\n"},
+		{"macrocell with a state too high", ".mc", "[M2]\n1 256 0 0 0\n2 1 0 0 0\n"},
+		{"macrocell with too many cells", ".mc", huge},
+		{"rle with too many cells", ".rle", "x = 3, y = 3\n2000000000o!"},
+	}
+	for _, test := range tests {
+		if pt, err := parsePattern(test.ext, test.data); err == nil {
+			t.Errorf("%v: read %v, want an error", test.name, pt.cells)
+		}
+	}
+}
+
+// readBack parses data as a pattern file with extension ext, checking it is read back as a world of the size given.
+func readBack(t *testing.T, name, ext, data string, rule stubs.Rule, width, height int) ([][]uint8, bool) {
+	pt, err := parsePattern(ext, data)
+	if err != nil {
+		t.Errorf("%v: %v", name, err)
+		return nil, false
+	}
+	if w, h := pt.size(); w != width || h != height {
+		t.Errorf("%v: read back as %vx%v", name, w, h)
+	}
+	got := make([][]uint8, height)
+	for y := range got {
+		got[y] = make([]uint8, width)
+	}
+	for i, cell := range pt.cells {
+		if cell.X < 0 || cell.X >= width || cell.Y < 0 || cell.Y >= height {
+			t.Errorf("%v: cell %v is outside the world", name, cell)
+			return nil, false
+		}
+		got[cell.Y][cell.X] = rule.Level(pt.states[i])
+	}
+	return got, true
+}
+
+// TestMacrocellRoundTrip tests that worlds saved as macrocell files are read back the same size,
+// with every cell where it was and in the state it was in.
+func TestMacrocellRoundTrip(t *testing.T) {
+	tests := []struct {
+		rule   string
+		width  int
+		height int
+	}{
+		{"B3/S23", 64, 48},
+		{"B3/S23", 100, 30},
+		{"B2/S/3", 64, 64},
+		{"B34678/S234/24", 37, 41},
+	}
+	for _, test := range tests {
+		rule, err := stubs.ParseRule(test.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		world := make([][]uint8, test.height)
+		for y := range world {
+			world[y] = make([]uint8, test.width)
+		}
+		for i, cell := range glider {
+			world[20+cell.Y][20+cell.X] = rule.Level(1 + i%2*(rule.States/2))
+		}
+		world[test.height-1][test.width-1] = 255
+
+		data := encodeMacrocell(world, rule, 7)
+		name := fmt.Sprintf("%v %vx%v", test.rule, test.width, test.height)
+		if got, ok := readBack(t, name, ".mc", data, rule, test.width, test.height); ok && fmt.Sprint(got) != fmt.Sprint(world) {
+			t.Errorf("%v: read back a different world from\n%v", name, data)
+		}
+	}
+}
+
+// TestRLERoundTrip tests that worlds saved as RLE files are read back the same size and with the same cells,
+// across empty rows and in states written with two letters.
+func TestRLERoundTrip(t *testing.T) {
+	tests := []struct {
+		rule   string
+		width  int
+		height int
+		states []int
+	}{
+		{"B3/S23", 40, 30, []int{1}},
+		{"B3/S23", 200, 3, []int{1}},
+		{"B34678/S234/24", 37, 41, []int{1, 5, 23}},
+		{"B2/S345/60", 50, 40, []int{1, 24, 25, 30, 47, 59}},
+	}
+	for _, test := range tests {
+		rule, err := stubs.ParseRule(test.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		world := make([][]uint8, test.height)
+		for y := range world {
+			world[y] = make([]uint8, test.width)
+		}
+		// A run of cells in each state along the top row, a glider below a gap of empty rows, and a cell in the
+		// bottom right corner so that the world ends where it should.
+		for i, state := range test.states {
+			world[0][2*i] = rule.Level(state)
+			world[0][2*i+1] = rule.Level(state)
+		}
+		for i, cell := range glider {
+			world[test.height/2+cell.Y-1][10+cell.X] = rule.Level(test.states[i%len(test.states)])
+		}
+		world[test.height-1][test.width-1] = 255
+
+		data := encodeRLE(world, rule, "round trip", 7)
+		name := fmt.Sprintf("%v %vx%v", test.rule, test.width, test.height)
+		if got, ok := readBack(t, name, ".rle", data, rule, test.width, test.height); ok && fmt.Sprint(got) != fmt.Sprint(world) {
+			t.Errorf("%v: read back a different world from\n%v", name, data)
+		}
+	}
+}
+
+// TestPlaintextRoundTrip tests that worlds saved as plaintext files are read back with the same cells,
+// including empty rows at the top, in the middle and at the bottom.
+func TestPlaintextRoundTrip(t *testing.T) {
+	for _, size := range []struct{ width, height int }{{5, 5}, {30, 12}, {1, 8}} {
+		world := make([][]uint8, size.height)
+		for y := range world {
+			world[y] = make([]uint8, size.width)
+		}
+		world[1][0] = 255
+		world[size.height-2][size.width-1] = 255
+
+		data := encodePlaintext(world, stubs.Life, "round trip", 7)
+		name := fmt.Sprintf("%vx%v", size.width, size.height)
+		if got, ok := readBack(t, name, ".cells", data, stubs.Life, size.width, size.height); ok && fmt.Sprint(got) != fmt.Sprint(world) {
+			t.Errorf("%v: read back a different world from\n%v", name, data)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package gol
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// errNotPGM is returned for files that do not start like PGM images.
+var errNotPGM = errors.New("not a PGM file")
+
+// pgmImage is a greyscale image read from a PGM file, with samples running from 0 for black up to maxval for white.
+type pgmImage struct {
+	width   int
+	height  int
+	maxval  int
+	samples []int
+}
+
+// readPGM reads a PGM image from path.
+func readPGM(path string) (*pgmImage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	img, err := decodePGM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return img, nil
+}
+
+// pgmReader reads the whitespace separated fields of a PGM file, skipping comments from # to the end of the line.
+type pgmReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *pgmReader) skipSpace() {
+	for r.pos < len(r.data) {
+		switch c := r.data[r.pos]; {
+		case c == '#':
+			for r.pos < len(r.data) && r.data[r.pos] != '\n' && r.data[r.pos] != '\r' {
+				r.pos++
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f':
+			r.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (r *pgmReader) field() string {
+	r.skipSpace()
+	start := r.pos
+	for r.pos < len(r.data) {
+		c := r.data[r.pos]
+		if c == '#' || c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f' {
+			break
+		}
+		r.pos++
+	}
+	return string(r.data[start:r.pos])
+}
+
+// number reads a field holding a number from 0 up to max.
+func (r *pgmReader) number(name string, max int) (int, error) {
+	field := r.field()
+	if field == "" {
+		return 0, fmt.Errorf("missing %v", name)
+	}
+	n, err := strconv.Atoi(field)
+	if err != nil || n < 0 || n > max {
+		return 0, fmt.Errorf("%v %q is not a number from 0 to %v", name, field, max)
+	}
+	return n, nil
+}
+
+// decodePGM reads a PGM image, either binary (P5) with one or, for a maxval above 255, two bytes per sample,
+// or plain (P2) with samples written out in decimal.
+func decodePGM(data []byte) (*pgmImage, error) {
+	r := &pgmReader{data: data}
+	magic := r.field()
+	if magic != "P5" && magic != "P2" {
+		return nil, errNotPGM
+	}
+	img := new(pgmImage)
+	var err error
+	if img.width, err = r.number("width", 1<<20); err != nil {
+		return nil, err
+	}
+	if img.height, err = r.number("height", 1<<20); err != nil {
+		return nil, err
+	}
+	if img.maxval, err = r.number("maxval", 65535); err != nil {
+		return nil, err
+	}
+	if img.width == 0 || img.height == 0 || img.maxval == 0 {
+		return nil, fmt.Errorf("image is %vx%v with maxval %v", img.width, img.height, img.maxval)
+	}
+
+	// The samples are only made room for once the data is known to hold them all, so that a header alone cannot
+	// ask for more memory than the file is worth.
+	count := img.width * img.height
+	if magic == "P2" {
+		// Each sample takes at least a digit and the whitespace after it.
+		if len(data)-r.pos < 2*count-1 {
+			return nil, fmt.Errorf("only %v bytes of samples for a %vx%v image", len(data)-r.pos, img.width, img.height)
+		}
+		img.samples = make([]int, count)
+		for i := range img.samples {
+			if img.samples[i], err = r.number("sample", img.maxval); err != nil {
+				return nil, err
+			}
+		}
+		return img, nil
+	}
+
+	// A single whitespace byte separates the header from the samples, which may themselves look like whitespace or #.
+	r.pos++
+	size := 1
+	if img.maxval > 255 {
+		size = 2
+	}
+	if len(data)-r.pos < count*size {
+		return nil, fmt.Errorf("only %v bytes of samples for a %vx%v image", len(data)-r.pos, img.width, img.height)
+	}
+	img.samples = make([]int, count)
+	for i := range img.samples {
+		sample := int(data[r.pos])
+		if size == 2 {
+			sample = sample<<8 | int(data[r.pos+1])
+		}
+		if sample > img.maxval {
+			return nil, fmt.Errorf("sample %v is above maxval %v", sample, img.maxval)
+		}
+		img.samples[i] = sample
+		r.pos += size
+	}
+	return img, nil
+}
+
+// world returns the cells of the image. With a threshold of zero, images with a maxval of 255 are taken
+// as they are, keeping the grey levels of dying cells under Generations rules, and other images are split halfway.
+// Otherwise cells whose samples, scaled to run up to 255, reach threshold are alive and the rest are dead.
+func (img *pgmImage) world(threshold int) [][]uint8 {
+	if threshold == 0 && img.maxval != 255 {
+		threshold = 128
+	}
+	world := make([][]uint8, img.height)
+	for y := range world {
+		world[y] = make([]uint8, img.width)
+		for x := range world[y] {
+			level := img.samples[y*img.width+x] * 255 / img.maxval
+			switch {
+			case threshold == 0:
+				world[y][x] = uint8(level)
+			case level >= threshold:
+				world[y][x] = 255
+			}
+		}
+	}
+	return world
+}
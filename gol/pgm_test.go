@@ -0,0 +1,81 @@
+package gol
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// TestDecodePGM tests binary and plain PGM images, with comments in their headers and with wide maxvals.
+func TestDecodePGM(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		width   int
+		height  int
+		maxval  int
+		samples []int
+	}{
+		{"binary", "P5 3 2 255\n\x00\xff\x10\x20\x30\x40", 3, 2, 255, []int{0, 255, 16, 32, 48, 64}},
+		{"binary with comments", "P5\n# made by hand\n3 2 # size\n255\n\x00\xff\x10\x20\x30\x40", 3, 2, 255, []int{0, 255, 16, 32, 48, 64}},
+		{"binary samples that look like whitespace", "P5 2 2 255\n\n #\t", 2, 2, 255, []int{10, 32, 35, 9}},
+		{"binary with a small maxval", "P5 2 1 1\n\x00\x01", 2, 1, 1, []int{0, 1}},
+		{"binary with two bytes per sample", "P5 2 1 65535\n\x01\x02\xff\xff", 2, 1, 65535, []int{258, 65535}},
+		{"binary with a maxval just over a byte", "P5 1 1 256\n\x01\x00", 1, 1, 256, []int{256}},
+		{"plain", "P2\n3 2\n255\n0 255 16\n32 48 64\n", 3, 2, 255, []int{0, 255, 16, 32, 48, 64}},
+		{"plain with comments", "P2 # plain\n3 # width\n2\n# maxval next\n255\n0 255 16 # first row\n32 48 64", 3, 2, 255, []int{0, 255, 16, 32, 48, 64}},
+		{"plain with a wide maxval", "P2 2 1 65535 1000 65535", 2, 1, 65535, []int{1000, 65535}},
+	}
+	for _, test := range tests {
+		img, err := decodePGM([]byte(test.data))
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if img.width != test.width || img.height != test.height || img.maxval != test.maxval {
+			t.Errorf("%v: %vx%v with maxval %v, want %vx%v with maxval %v", test.name,
+				img.width, img.height, img.maxval, test.width, test.height, test.maxval)
+		}
+		if fmt.Sprint(img.samples) != fmt.Sprint(test.samples) {
+			t.Errorf("%v: samples %v, want %v", test.name, img.samples, test.samples)
+		}
+	}
+}
+
+// TestDecodePGMErrors tests that malformed PGM images are rejected, and that files which are not PGM images at all
+// are told apart from broken ones.
+func TestDecodePGMErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		notPGM bool
+	}{
+		{"empty", "", true},
+		{"pattern", "x = 3, y = 3\nbo$2bo$3o!", true},
+		{"portable bitmap", "P4 8 1\n\xff", true},
+		{"missing height", "P5 3", false},
+		{"zero width", "P5 0 2 255\n", false},
+		{"zero maxval", "P5 1 1 0\n\x00", false},
+		{"maxval too wide", "P5 1 1 65536\n\x00\x00", false},
+		{"negative width", "P2 -1 2 255\n0 0", false},
+		{"width too large", "P5 1048577 1 255\n", false},
+		{"huge header", "P5 1048576 1048576 255\n\x00", false},
+		{"huge plain header", "P2 1048576 1048576 255\n0 0 0", false},
+		{"truncated binary", "P5 3 2 255\n\x00\xff\x10", false},
+		{"truncated two byte binary", "P5 2 1 65535\n\x01\x02\xff", false},
+		{"truncated plain", "P2 3 2 255\n0 255 16\n32 48", false},
+		{"binary sample above maxval", "P5 2 1 15\n\x0f\x10", false},
+		{"plain sample above maxval", "P2 2 1 15\n15 16", false},
+		{"plain sample not a number", "P2 2 1 255\n15 x6", false},
+	}
+	for _, test := range tests {
+		img, err := decodePGM([]byte(test.data))
+		if err == nil {
+			t.Errorf("%v: read %+v, want an error", test.name, img)
+			continue
+		}
+		if errors.Is(err, errNotPGM) != test.notPGM {
+			t.Errorf("%v: error %q, want it to be %v that the file is not a PGM image", test.name, err, test.notPGM)
+		}
+	}
+}
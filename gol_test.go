@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/stubs"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
@@ -42,3 +43,41 @@ func TestGol(t *testing.T) {
 		}
 	}
 }
+
+// TestEngines tests the engines other than cells on the same images and turns as TestGol, using 1, 3 and 16 worker threads.
+func TestEngines(t *testing.T) {
+	tests := []gol.Params{
+		{ImageWidth: 16, ImageHeight: 16},
+		{ImageWidth: 64, ImageHeight: 64},
+		{ImageWidth: 512, ImageHeight: 512},
+	}
+	for _, engine := range []stubs.Engine{stubs.EngineHashLife, stubs.EngineSparse, stubs.EngineBitboard} {
+		for _, p := range tests {
+			p.Engine = engine
+			for _, turns := range []int{0, 1, 100} {
+				p.Turns = turns
+				expectedAlive := readAliveCells(
+					"check/images/"+fmt.Sprintf("%vx%vx%v.pgm", p.ImageWidth, p.ImageHeight, turns),
+					p.ImageWidth,
+					p.ImageHeight,
+				)
+				for _, threads := range []int{1, 3, 16} {
+					p.Threads = threads
+					testName := fmt.Sprintf("%v-%dx%dx%d-%d", p.Engine, p.ImageWidth, p.ImageHeight, p.Turns, p.Threads)
+					t.Run(testName, func(t *testing.T) {
+						events := make(chan gol.Event)
+						go gol.Run(p, events, nil)
+						var cells []util.Cell
+						for event := range events {
+							switch e := event.(type) {
+							case gol.FinalTurnComplete:
+								cells = e.Alive
+							}
+						}
+						assertEqualBoard(t, cells, expectedAlive, p)
+					})
+				}
+			}
+		}
+	}
+}
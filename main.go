@@ -6,10 +6,13 @@ import (
 	"runtime"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 
 	"uk.ac.bris.cs/gameoflife/gol"
 	"uk.ac.bris.cs/gameoflife/sdl"
+	"uk.ac.bris.cs/gameoflife/stubs"
 )
 
 // main is the function called when starting Game of Life with 'go run .'
@@ -41,17 +44,251 @@ func main() {
 		10000000000,
 		"Specify the number of turns to process. Defaults to 10000000000.")
 
+	flag.Func(
+		"rule",
+		"Specify the rule to run in B/S notation, such as B36/S23 or B2/S34H, in Larger than Life notation, such as R5,C0,M1,S34..58,B34..45,NM, or by name, such as highlife. Defaults to B3/S23.",
+		func(s string) error {
+			rule, err := stubs.ParseRule(s)
+			params.Rule = rule
+			return err
+		})
+
+	flag.Func(
+		"boundary",
+		"Specify what lies beyond the edges of the world: torus, dead, reflect, klein or cylinder. Defaults to torus.",
+		func(s string) error {
+			boundary, err := stubs.ParseBoundary(s)
+			params.Boundary = boundary
+			return err
+		})
+
+	flag.Func(
+		"engine",
+		"Specify how the server evolves the world: cells, hashlife for leaping ahead on sparse or repeating patterns, sparse for skipping settled areas, or bitboard for stepping 64 cells at a time. Defaults to cells.",
+		func(s string) error {
+			engine, err := stubs.ParseEngine(s)
+			params.Engine = engine
+			return err
+		})
+
+	flag.Func(
+		"cycles",
+		"Specify whether the server watches for the world repeating itself: off, detect to report the cycle, or stop to also skip the remaining turns. Defaults to off.",
+		func(s string) error {
+			cycles, err := stubs.ParseCycles(s)
+			params.Cycles = cycles
+			return err
+		})
+
+	input := flag.String(
+		"input",
+		"",
+		"Specify a PGM image or pattern file to start from, taking the width and height from it unless -fit is given.")
+
+	flag.BoolVar(
+		&params.Fit,
+		"fit",
+		false,
+		"Crop the input, or pad it with dead cells, to fit a world of -w by -h. Defaults to failing if a PGM image is another size.")
+
+	flag.StringVar(
+		&params.Image,
+		"image",
+		"",
+		"Specify a PGM image to start from, in binary (P5) or plain (P2) form. Defaults to images/<w>x<h>.pgm.")
+
+	flag.Func(
+		"threshold",
+		"Specify the grey level from 1 to 255, scaled from the image's maxval, at which cells in the image are alive. Defaults to keeping the levels of images with a maxval of 255 and halfway for others.",
+		func(s string) error {
+			threshold, err := strconv.Atoi(s)
+			if err != nil || threshold < 1 || threshold > 255 {
+				return fmt.Errorf("threshold %q is not a grey level from 1 to 255", s)
+			}
+			params.Threshold = threshold
+			return nil
+		})
+
+	flag.StringVar(
+		&params.Pattern,
+		"pattern",
+		"",
+		"Specify an RLE, Life 1.05, Life 1.06, plaintext or macrocell file to start from. Defaults to images/<w>x<h>.pgm.")
+
+	flag.Func(
+		"offset",
+		"Specify where to place the top left corner of the pattern, as x,y. Defaults to 0,0.",
+		func(s string) error {
+			_, err := fmt.Sscanf(s, "%d,%d", &params.PatternOffset.X, &params.PatternOffset.Y)
+			if err != nil {
+				return fmt.Errorf("offset %q is not of the form x,y", s)
+			}
+			return nil
+		})
+
+	flag.Func(
+		"format",
+		"Specify the format worlds are saved in under out/: pgm, rle, plaintext, macrocell or png, or a file name with one of their extensions. Defaults to pgm.",
+		func(s string) error {
+			format, err := gol.ParseFormat(s)
+			params.Format = format
+			return err
+		})
+
+	flag.IntVar(
+		&params.Scale,
+		"scale",
+		1,
+		"Specify how many pixels wide each cell is drawn in PNG and GIF output. Defaults to 1.")
+
+	flag.Func(
+		"palette",
+		"Specify the colours of PNG and GIF output: grey, inverted, green or amber, or the colours of dead and live cells, such as #ffffff,#0000ff. Defaults to grey.",
+		func(s string) error {
+			palette, err := gol.ParsePalette(s)
+			params.Palette = palette
+			return err
+		})
+
+	flag.Func(
+		"animate",
+		"Specify the turns to record as an animated GIF under out/, as from:to. Defaults to recording no animation.",
+		func(s string) error {
+			_, err := fmt.Sscanf(s, "%d:%d", &params.AnimateFrom, &params.AnimateTo)
+			if err != nil || params.AnimateFrom < 0 || params.AnimateTo < params.AnimateFrom || params.AnimateTo == 0 {
+				return fmt.Errorf("turns %q are not of the form from:to", s)
+			}
+			return nil
+		})
+
+	flag.StringVar(
+		&params.Session,
+		"session",
+		"",
+		"Specify the ID of a running session to reattach to. Defaults to starting a new session.")
+
+	flag.BoolVar(
+		&params.Resume,
+		"resume",
+		false,
+		"Resume the session given by -session, or the most recent one, from its latest checkpoint after the server has been restarted.")
+
+	flag.StringVar(
+		&params.Owner,
+		"owner",
+		os.Getenv("USER"),
+		"Specify who is starting or cancelling a session. Defaults to $USER.")
+
+	list := flag.Bool(
+		"list",
+		false,
+		"List the sessions on the server and exit.")
+
+	cancel := flag.String(
+		"cancel",
+		"",
+		"Specify the ID of one of your sessions to cancel, then exit.")
+
+	servers := flag.String(
+		"server",
+		gol.DefaultServer,
+		"Specify a comma-separated list of broker addresses to try in order. Defaults to "+gol.DefaultServer+".")
+
+	flag.DurationVar(
+		&params.Timeout,
+		"timeout",
+		gol.DefaultTimeout,
+		"Specify how long to wait for each server to answer. Defaults to 5s.")
+
+	streamSet := false
+	flag.Func(
+		"stream",
+		"Specify how flipped cells are sent to the SDL window: turns, frames or off. Defaults to turns, or off when headless.",
+		func(mode string) error {
+			streamSet = true
+			switch mode {
+			case "turns":
+				params.Stream = gol.StreamTurns
+			case "frames":
+				params.Stream = gol.StreamFrames
+			case "off":
+				params.Stream = gol.StreamOff
+			default:
+				return fmt.Errorf("unknown stream mode %q", mode)
+			}
+			return nil
+		})
+
 	headless := flag.Bool(
 		"headless",
 		false,
 		"Disable the SDL window for running in a headless environment.")
 
 	flag.Parse()
+	params.Servers = strings.Split(*servers, ",")
+	params.FrameRate = sdl.FPS
+	if *headless && !streamSet {
+		params.Stream = gol.StreamOff
+	}
+
+	if *list {
+		sessions, err := gol.Sessions(params)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%-10v %-12v %-10v %-14v %-10v %-24v %-8v %v\n", "Session", "Owner", "Size", "Rule", "Boundary", "Turns", "Alive", "State")
+		for _, s := range sessions {
+			state := "Executing"
+			switch {
+			case s.Finished:
+				state = "Finished"
+			case s.Paused:
+				state = "Paused"
+			}
+			if s.Detached {
+				state += ", detached"
+			}
+			fmt.Printf("%-10v %-12v %-10v %-14v %-10v %-24v %-8v %v\n", s.Session, s.Owner,
+				fmt.Sprintf("%vx%v", s.ImageWidth, s.ImageHeight), s.Rule, s.Boundary,
+				fmt.Sprintf("%v/%v", s.CompletedTurns, s.Turns), s.AliveCells, state)
+		}
+		return
+	}
+	if *cancel != "" {
+		err := gol.Cancel(params, *cancel)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Cancelled session", *cancel)
+		return
+	}
+
+	if *input != "" {
+		width, height, image, err := gol.InputSize(*input)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		if image {
+			params.Image = *input
+		} else {
+			params.Pattern = *input
+		}
+		if !params.Fit {
+			params.ImageWidth, params.ImageHeight = width, height
+		}
+	}
 
 	fmt.Printf("%-10v %v\n", "Threads", params.Threads)
 	fmt.Printf("%-10v %v\n", "Width", params.ImageWidth)
 	fmt.Printf("%-10v %v\n", "Height", params.ImageHeight)
 	fmt.Printf("%-10v %v\n", "Turns", params.Turns)
+	fmt.Printf("%-10v %v\n", "Rule", params.Rule)
+	fmt.Printf("%-10v %v\n", "Boundary", params.Boundary)
+	fmt.Printf("%-10v %v\n", "Engine", params.Engine)
+	fmt.Printf("%-10v %v\n", "Cycles", params.Cycles)
 
 	keyPresses := make(chan rune, 10)
 	events := make(chan gol.Event, 1000)
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// resumeServer is where TestResume runs its own server, away from the one the other tests use.
+const resumeServer = "127.0.0.1:8090"
+
+// startServer runs the server binary on resumeServer, saving checkpoints to dir, and waits for it to accept connections.
+func startServer(t *testing.T, binary, dir string) *exec.Cmd {
+	_, port, _ := net.SplitHostPort(resumeServer)
+	server := exec.Command(binary, "-port", port, "-checkpoints", dir, "-checkpoint-interval", "50ms")
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = server.Process.Kill()
+		_ = server.Wait()
+	})
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(50 * time.Millisecond) {
+		conn, err := net.Dial("tcp", resumeServer)
+		if err == nil {
+			conn.Close()
+			return server
+		}
+	}
+	t.Fatal("server did not start")
+	return nil
+}
+
+// runToEnd runs p, returning the turn it started from, the final turn and the cells alive at the end.
+func runToEnd(t *testing.T, p gol.Params) (int, int, []util.Cell) {
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	started, final := -1, -1
+	var cells []util.Cell
+	for event := range events {
+		switch e := event.(type) {
+		case gol.StateChange:
+			if started < 0 {
+				started = e.CompletedTurns
+			}
+		case gol.FinalTurnComplete:
+			final, cells = e.CompletedTurns, e.Alive
+		case gol.ErrorOccurred:
+			t.Fatal(e)
+		}
+	}
+	return started, final, cells
+}
+
+// TestResume kills the server partway through a run, restarts it and resumes the run from its checkpoint,
+// then checks that it ends in the same state as an uninterrupted run.
+func TestResume(t *testing.T) {
+	binary := filepath.Join(t.TempDir(), "server")
+	build := exec.Command("go", "build", "-o", binary, "./server")
+	build.Stdout, build.Stderr = os.Stdout, os.Stderr
+	if err := build.Run(); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	p := gol.Params{ImageWidth: 512, ImageHeight: 512, Turns: 2000, Threads: 4, Stream: gol.StreamOff, Servers: []string{resumeServer}}
+
+	// Kill the server once the run has been checkpointed a few times.
+	server := startServer(t, binary, dir)
+	interrupted := make(chan struct{})
+	go func() {
+		defer close(interrupted)
+		events := make(chan gol.Event)
+		go gol.Run(p, events, nil)
+		for range events {
+		}
+	}()
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.checkpoint"))
+		if len(matches) > 0 {
+			break
+		}
+		if time.Since(start) > 30*time.Second {
+			t.Fatal("no checkpoint was written")
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+	_ = server.Process.Kill()
+	_ = server.Wait()
+	<-interrupted
+
+	startServer(t, binary, dir)
+	resumed := p
+	resumed.Resume = true
+	from, final, cells := runToEnd(t, resumed)
+	if from <= 0 || from >= p.Turns {
+		t.Fatalf("resumed from turn %v, expected a turn partway through the %v turns", from, p.Turns)
+	}
+	if final != p.Turns {
+		t.Fatalf("resumed run ended at turn %v, expected %v", final, p.Turns)
+	}
+
+	_, _, expected := runToEnd(t, p)
+	assertEqualBoard(t, cells, expected, p)
+}
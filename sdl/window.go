@@ -76,6 +76,19 @@ func (w *Window) SetPixel(x, y int) {
 	w.pixels[4*(y*width+x)+3] = 0xFF
 }
 
+// ShadePixel sets the pixel at (x, y) to the grey level given.
+func (w *Window) ShadePixel(x, y int, level uint8) {
+	if x < 0 || y < 0 || x >= int(w.Width) || y >= int(w.Height) {
+		panic(fmt.Sprintf("CellsShaded event at (%d, %d) is outside the bounds of the window.", x, y))
+	}
+
+	width := int(w.Width)
+	w.pixels[4*(y*width+x)+0] = level
+	w.pixels[4*(y*width+x)+1] = level
+	w.pixels[4*(y*width+x)+2] = level
+	w.pixels[4*(y*width+x)+3] = 0xFF
+}
+
 func (w *Window) FlipPixel(x, y int) {
 	if x < 0 || y < 0 || x >= int(w.Width) || y >= int(w.Height) {
 		panic(fmt.Sprintf("CellFlipped event at (%d, %d) is outside the bounds of the window.", x, y))
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"math/bits"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// errBitboard is returned for runs the bitboard engine cannot evolve.
+var errBitboard = errors.New("the bitboard engine only runs two-state rules in B/S notation on the Moore neighbourhood")
+
+// checkBitboard reports whether req can be run by the bitboard engine.
+func checkBitboard(req stubs.Request) error {
+	rule := newLifeRule(req.Rule)
+	if rule.wide || rule.generations {
+		return errBitboard
+	}
+	return nil
+}
+
+// bitboardEngine steps the world on this machine with 64 cells packed into each word, bit x%64 of word x/64
+// holding cell x of a row. Each turn it adds up the eight neighbours of 64 cells at once with bitwise adders.
+type bitboardEngine struct {
+	rule     *lifeRule
+	topology *topology
+	height   int
+	width    int
+	threads  int
+	words    int
+	// last masks off the bits of the last word of each row that lie beyond the edge of the world.
+	last    uint64
+	current [][]uint64
+	next    [][]uint64
+	blank   []uint64
+}
+
+func newBitboardEngine(req stubs.Request, world [][]uint8) *bitboardEngine {
+	e := &bitboardEngine{
+		rule:     newLifeRule(req.Rule),
+		topology: newTopology(req.Boundary, req.ImageWidth),
+		height:   req.ImageHeight,
+		width:    req.ImageWidth,
+		threads:  req.Threads,
+		words:    (req.ImageWidth + 63) / 64,
+		last:     ^uint64(0) >> (63 - (req.ImageWidth-1)%64),
+	}
+	e.current = e.board()
+	e.next = e.board()
+	e.blank = make([]uint64, e.words)
+	for y, row := range world {
+		for x, cell := range row {
+			if cell == 255 {
+				e.current[y][x/64] |= 1 << (x % 64)
+			}
+		}
+	}
+	return e
+}
+
+func (e *bitboardEngine) board() [][]uint64 {
+	board := make([][]uint64, e.height)
+	for y := range board {
+		board[y] = make([]uint64, e.words)
+	}
+	return board
+}
+
+// cell returns cell x of row, or 0 for -1, the column beyond a dead edge.
+func cell(row []uint64, x int) uint64 {
+	if x < 0 {
+		return 0
+	}
+	return row[x/64] >> (x % 64) & 1
+}
+
+// beyond returns the row standing in for the one beyond the top or bottom edge of the world,
+// given the row along that edge and the row along the opposite edge.
+func (e *bitboardEngine) beyond(edge, opposite []uint64) []uint64 {
+	switch e.topology.boundary {
+	case stubs.BoundaryDead, stubs.BoundaryCylinder:
+		return e.blank
+	case stubs.BoundaryReflect:
+		return edge
+	case stubs.BoundaryKlein:
+		reversed := make([]uint64, e.words)
+		for x := 0; x < e.width; x++ {
+			reversed[x/64] |= cell(opposite, e.width-1-x) << (x % 64)
+		}
+		return reversed
+	default:
+		return opposite
+	}
+}
+
+// west fills out with row moved one cell along, so that each cell holds its western neighbour.
+func (e *bitboardEngine) west(row, out []uint64) {
+	carry := cell(row, e.topology.left)
+	for k, word := range row {
+		out[k] = word<<1 | carry
+		carry = word >> 63
+	}
+}
+
+// east fills out with row moved one cell back, so that each cell holds its eastern neighbour.
+func (e *bitboardEngine) east(row, out []uint64) {
+	for k := 0; k < e.words-1; k++ {
+		out[k] = row[k]>>1 | row[k+1]<<63
+	}
+	out[e.words-1] = row[e.words-1]>>1 | cell(row, e.topology.right)<<((e.width-1)%64)
+}
+
+// fullAdd adds three bits in each position, returning the ones and the carries.
+func fullAdd(a, b, c uint64) (sum, carry uint64) {
+	ab := a ^ b
+	return ab ^ c, a&b | c&ab
+}
+
+// stepWord computes the next state of the 64 cells in word, given their eight neighbours in each position.
+func (e *bitboardEngine) stepWord(word, n0, n1, n2, n3, n4, n5, n6, n7 uint64) uint64 {
+	// Add up the neighbours into the four bits of a count from 0 to 8.
+	sa, ca := fullAdd(n0, n1, n2)
+	sb, cb := fullAdd(n3, n4, n5)
+	sc, cc := n6^n7, n6&n7
+	ones, cd := fullAdd(sa, sb, sc)
+	st, ce := fullAdd(ca, cb, cc)
+	twos, cf := st^cd, st&cd
+	fours, eights := ce^cf, ce&cf
+
+	var next uint64
+	for n := 0; n <= 8; n++ {
+		born, survives := e.rule.birth&(1<<n) != 0, e.rule.survival&(1<<n) != 0
+		if !born && !survives {
+			continue
+		}
+		count := ^uint64(0)
+		for i, bit := range [4]uint64{ones, twos, fours, eights} {
+			if n&(1<<i) == 0 {
+				bit = ^bit
+			}
+			count &= bit
+		}
+		switch {
+		case born && survives:
+			next |= count
+		case born:
+			next |= count &^ word
+		default:
+			next |= count & word
+		}
+	}
+	return next
+}
+
+func (e *bitboardEngine) step(track bool) (int, flips, error) {
+	top := e.beyond(e.current[0], e.current[e.height-1])
+	bottom := e.beyond(e.current[e.height-1], e.current[0])
+
+	var flipped flips
+	bands := make([]flips, e.height)
+	alive := splitRows(e.height, e.threads, func(startY, endY int) int {
+		alive := 0
+		shifted := make([][]uint64, 6)
+		for i := range shifted {
+			shifted[i] = make([]uint64, e.words)
+		}
+		aboveW, aboveE, rowW, rowE, belowW, belowE := shifted[0], shifted[1], shifted[2], shifted[3], shifted[4], shifted[5]
+		for y := startY; y < endY; y++ {
+			above, row, below := top, e.current[y], bottom
+			if y > 0 {
+				above = e.current[y-1]
+			}
+			if y < e.height-1 {
+				below = e.current[y+1]
+			}
+			e.west(above, aboveW)
+			e.east(above, aboveE)
+			e.west(row, rowW)
+			e.east(row, rowE)
+			e.west(below, belowW)
+			e.east(below, belowE)
+
+			next := e.next[y]
+			for k := range next {
+				next[k] = e.stepWord(row[k], aboveW[k], above[k], aboveE[k], rowW[k], rowE[k], belowW[k], below[k], belowE[k])
+			}
+			next[e.words-1] &= e.last
+
+			for k, word := range next {
+				alive += bits.OnesCount64(word)
+				if !track {
+					continue
+				}
+				for changed := word ^ row[k]; changed != 0; changed &= changed - 1 {
+					x := k*64 + bits.TrailingZeros64(changed)
+					bands[startY].cells = append(bands[startY].cells, util.Cell{X: x, Y: y})
+				}
+			}
+		}
+		return alive
+	})
+	for _, band := range bands {
+		flipped.append(band)
+	}
+
+	e.current, e.next = e.next, e.current
+	return alive, flipped, nil
+}
+
+// hash hashes the packed rows as they are, which is quicker than unpacking them. The hashes differ from
+// those of the other engines, but a session only ever compares hashes from the same engine.
+func (e *bitboardEngine) hash() (uint64, error) {
+	var hash uint64
+	for y, row := range e.current {
+		h := uint64(14695981039346656037)
+		for _, word := range row {
+			h ^= word
+			h *= 1099511628211
+		}
+		hash += placeRow(y, h)
+	}
+	return hash, nil
+}
+
+func (e *bitboardEngine) world() ([][]uint8, error) {
+	world := make([][]uint8, e.height)
+	for y, row := range e.current {
+		world[y] = make([]uint8, e.width)
+		for x := range world[y] {
+			if cell(row, x) == 1 {
+				world[y][x] = 255
+			}
+		}
+	}
+	return world, nil
+}
+
+func (e *bitboardEngine) close() {
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/rpc"
+	"strings"
+	"sync"
+	"time"
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// heartbeatInterval is how often the broker checks that each worker in a run is still responding.
+const heartbeatInterval = time.Second
+
+// heartbeatTimeout is how long a worker has to answer a heartbeat before it is treated as lost.
+const heartbeatTimeout = 5 * time.Second
+
+// checkpointInterval is how often the broker collects the world from its workers, so that
+// a lost worker only costs the turns since the last checkpoint.
+const checkpointInterval = 5 * time.Second
+
+// Register adds a worker to the pool used to split future runs.
+func (s *GolOperations) Register(req stubs.RegisterRequest, res *stubs.RegisterResponse) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, worker := range s.workers {
+		if worker == req.Address {
+			return
+		}
+	}
+	s.workers = append(s.workers, req.Address)
+	log.Println("Registered worker", req.Address)
+	return
+}
+
+// pool returns a copy of the addresses of the registered workers.
+func (s *GolOperations) pool() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.workers...)
+}
+
+// forget removes a lost worker from the pool. It is added back when it next registers, which workers do every registerInterval.
+func (s *GolOperations) forget(address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, worker := range s.workers {
+		if worker == address {
+			s.workers = append(s.workers[:i], s.workers[i+1:]...)
+			return
+		}
+	}
+}
+
+// remoteWorker is a connection to a worker taking part in a run.
+type remoteWorker struct {
+	address string
+	client  *rpc.Client
+	// lost is closed once the worker has failed a call or a heartbeat.
+	lost chan struct{}
+	once sync.Once
+}
+
+// fail marks the worker as lost and hangs up on it, so that any call still waiting on it returns.
+func (w *remoteWorker) fail() {
+	w.once.Do(func() {
+		close(w.lost)
+		w.client.Close()
+	})
+}
+
+func (w *remoteWorker) failed() bool {
+	select {
+	case <-w.lost:
+		return true
+	default:
+		return false
+	}
+}
+
+// monitor sends the worker a heartbeat every heartbeatInterval until stop is closed,
+// failing it if a heartbeat goes unanswered for heartbeatTimeout.
+func (w *remoteWorker) monitor(stop <-chan struct{}) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		case <-w.lost:
+			return
+		}
+		call := w.client.Go(stubs.HeartbeatHandler, stubs.ControlRequest{}, new(stubs.Response), nil)
+		select {
+		case <-call.Done:
+			if call.Error != nil {
+				w.fail()
+				return
+			}
+		case <-time.After(heartbeatTimeout):
+			w.fail()
+			return
+		case <-stop:
+			return
+		}
+	}
+}
+
+// remoteStrip is the broker's view of a strip held by a worker.
+type remoteStrip struct {
+	id     int
+	worker *remoteWorker
+	startY int
+	endY   int
+	top    stubs.Packed
+	bottom stubs.Packed
+	// hashes holds the hash of each row of the strip, kept while the session watches for cycles.
+	hashes []uint64
+}
+
+// remoteEngine splits the world into horizontal strips, one per reachable worker, and steps
+// them in lockstep. Only the first and last rows of each strip, as many as the rule's radius, travel between turns.
+// If a worker is lost, the world is split again between the workers that are left, starting from
+// the last checkpoint and replaying the turns since. With no workers left, the run carries on locally.
+type remoteEngine struct {
+	s        *GolOperations
+	req      stubs.Request
+	topology *topology
+	workers  []*remoteWorker
+	strips   []*remoteStrip
+	local    *localEngine
+	warn     func(string)
+	stop     chan struct{}
+	// hashing asks the workers to hash their strips every turn, for sessions watching for cycles.
+	hashing bool
+
+	turn           int
+	checkpoint     [][]uint8
+	checkpointTurn int
+	checkpointAt   time.Time
+}
+
+func (s *GolOperations) newRemoteEngine(req stubs.Request, world [][]uint8, workers []string, warn func(string)) (*remoteEngine, error) {
+	e := &remoteEngine{s: s, req: req, topology: newTopology(req.Boundary, req.ImageWidth), warn: warn, stop: make(chan struct{}),
+		hashing: req.Cycles != stubs.CyclesOff, checkpoint: world, checkpointAt: time.Now()}
+	for _, address := range workers {
+		// Every strip must be at least as tall as the halo its neighbours need from it.
+		if len(e.workers) == req.ImageHeight/req.Rule.Radius() {
+			break
+		}
+		client, err := rpc.Dial("tcp", address)
+		if err != nil {
+			log.Println("Skipping worker", address, err)
+			continue
+		}
+		w := &remoteWorker{address: address, client: client, lost: make(chan struct{})}
+		e.workers = append(e.workers, w)
+		go w.monitor(e.stop)
+	}
+	if len(e.workers) == 0 {
+		return nil, errors.New("no reachable workers")
+	}
+
+	err := e.split(world)
+	if err != nil {
+		e.close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// healthy drops the workers that have been lost from the run and from the pool, returning their addresses.
+func (e *remoteEngine) healthy() (lost []string) {
+	var healthy []*remoteWorker
+	for _, w := range e.workers {
+		if w.failed() {
+			e.s.forget(w.address)
+			lost = append(lost, w.address)
+			continue
+		}
+		healthy = append(healthy, w)
+	}
+	e.workers = healthy
+	return lost
+}
+
+// split hands one strip of world to each worker, replacing any strips they held before.
+func (e *remoteEngine) split(world [][]uint8) error {
+	e.free()
+	workers := e.workers
+	for i, w := range workers {
+		st := &remoteStrip{worker: w}
+		e.s.mu.Lock()
+		e.s.nextID++
+		st.id = e.s.nextID
+		e.s.mu.Unlock()
+
+		st.startY = i * e.req.ImageHeight / len(workers)
+		st.endY = (i + 1) * e.req.ImageHeight / len(workers)
+		r := e.req.Rule.Radius()
+		st.top = stubs.Pack(world[st.startY : st.startY+r])
+		st.bottom = stubs.Pack(world[st.endY-r : st.endY])
+		if e.hashing {
+			for _, row := range world[st.startY:st.endY] {
+				st.hashes = append(st.hashes, rowHash(row))
+			}
+		}
+		stripReq := stubs.StripRequest{
+			ID:         st.id,
+			Strip:      stubs.Pack(world[st.startY:st.endY]),
+			ImageWidth: e.req.ImageWidth,
+			Threads:    e.req.Threads,
+			Rule:       e.req.Rule,
+			Boundary:   e.req.Boundary,
+		}
+		err := w.client.Call(stubs.StripInitHandler, stripReq, new(stubs.StripResponse))
+		if err != nil {
+			w.fail()
+			return err
+		}
+		e.strips = append(e.strips, st)
+	}
+	return nil
+}
+
+// recover rebuilds the strips from the last checkpoint on the workers that are left and replays
+// the turns since, moving the run to the broker if every worker has been lost.
+func (e *remoteEngine) recover() {
+	for {
+		lost := strings.Join(e.healthy(), ", ")
+		if len(e.workers) == 0 {
+			e.warn(fmt.Sprintf("Lost worker %v at turn %v, no workers left so resuming from turn %v on the broker",
+				lost, e.turn, e.checkpointTurn))
+			e.free()
+			e.local = newLocalEngine(e.req, e.checkpoint)
+			for turn := e.checkpointTurn; turn < e.turn; turn++ {
+				e.local.step(false)
+			}
+			return
+		}
+		if lost != "" {
+			e.warn(fmt.Sprintf("Lost worker %v at turn %v, resuming from turn %v on %v workers",
+				lost, e.turn, e.checkpointTurn, len(e.workers)))
+		}
+		if e.replay() == nil {
+			return
+		}
+	}
+}
+
+// replay splits the last checkpoint between the workers and steps it back up to the current turn.
+func (e *remoteEngine) replay() error {
+	err := e.split(e.checkpoint)
+	for turn := e.checkpointTurn; turn < e.turn && err == nil; turn++ {
+		_, _, err = e.stepStrips(false)
+	}
+	return err
+}
+
+func (e *remoteEngine) step(track bool) (int, flips, error) {
+	if e.local != nil {
+		return e.local.step(track)
+	}
+	alive, flipped, err := e.stepStrips(track)
+	for err != nil {
+		e.recover()
+		if e.local != nil {
+			return e.local.step(track)
+		}
+		alive, flipped, err = e.stepStrips(track)
+	}
+	e.turn++
+
+	if time.Since(e.checkpointAt) > checkpointInterval {
+		_, err = e.world()
+	}
+	return alive, flipped, err
+}
+
+// stepStrips steps every strip once, failing the workers whose calls went wrong.
+func (e *remoteEngine) stepStrips(track bool) (int, flips, error) {
+	calls := make([]*rpc.Call, len(e.strips))
+	responses := make([]*stubs.HaloResponse, len(e.strips))
+	for i, st := range e.strips {
+		above := e.strips[(i+len(e.strips)-1)%len(e.strips)]
+		below := e.strips[(i+1)%len(e.strips)]
+		top, bottom := above.bottom, below.top
+		if i == 0 {
+			top = e.beyond(st.top, above.bottom)
+		}
+		if i == len(e.strips)-1 {
+			bottom = e.beyond(st.bottom, below.top)
+		}
+		responses[i] = new(stubs.HaloResponse)
+		haloReq := stubs.HaloRequest{ID: st.id, Top: top, Bottom: bottom, Track: track, Hash: e.hashing}
+		calls[i] = st.worker.client.Go(stubs.StripStepHandler, haloReq, responses[i], nil)
+	}
+
+	alive := 0
+	var flipped flips
+	var err error
+	for i, call := range calls {
+		<-call.Done
+		if call.Error != nil {
+			e.strips[i].worker.fail()
+			err = call.Error
+			continue
+		}
+		e.strips[i].top = responses[i].Top
+		e.strips[i].bottom = responses[i].Bottom
+		e.strips[i].hashes = responses[i].Hashes
+		alive += responses[i].Alive
+		for _, cell := range responses[i].Flipped.Cells() {
+			cell.Y += e.strips[i].startY
+			flipped.cells = append(flipped.cells, cell)
+		}
+		flipped.levels = append(flipped.levels, responses[i].Levels...)
+	}
+	return alive, flipped, err
+}
+
+// beyond is topology.beyond for the packed edge rows the broker keeps of each strip.
+func (e *remoteEngine) beyond(edge, opposite stubs.Packed) stubs.Packed {
+	if e.topology.boundary == stubs.BoundaryTorus {
+		return opposite
+	}
+	return stubs.Pack(e.topology.beyond(edge.Unpack(), opposite.Unpack()))
+}
+
+// hash adds up the hashes of the rows of the strips as they were after the last turn.
+func (e *remoteEngine) hash() (uint64, error) {
+	if e.local != nil {
+		return e.local.hash()
+	}
+	var hash uint64
+	for _, st := range e.strips {
+		for i, h := range st.hashes {
+			hash += placeRow(st.startY+i, h)
+		}
+	}
+	return hash, nil
+}
+
+// world collects the strips from the workers, keeping the result as the new checkpoint.
+func (e *remoteEngine) world() ([][]uint8, error) {
+	for e.local == nil {
+		world, err := e.collect()
+		if err == nil {
+			e.checkpoint, e.checkpointTurn, e.checkpointAt = world, e.turn, time.Now()
+			return world, nil
+		}
+		e.recover()
+	}
+	return e.local.world()
+}
+
+func (e *remoteEngine) collect() ([][]uint8, error) {
+	world := make([][]uint8, 0, e.req.ImageHeight)
+	for _, st := range e.strips {
+		res := new(stubs.StripResponse)
+		err := st.worker.client.Call(stubs.StripCollectHandler, stubs.StripRequest{ID: st.id}, res)
+		if err != nil {
+			st.worker.fail()
+			return nil, err
+		}
+		world = append(world, res.Strip.Unpack()...)
+	}
+	return world, nil
+}
+
+// free releases the strips held by the workers that are still reachable.
+func (e *remoteEngine) free() {
+	for _, st := range e.strips {
+		if !st.worker.failed() {
+			_ = st.worker.client.Call(stubs.StripFreeHandler, stubs.StripRequest{ID: st.id}, new(stubs.StripResponse))
+		}
+	}
+	e.strips = nil
+}
+
+// close frees the strips on the workers and hangs up on them.
+func (e *remoteEngine) close() {
+	close(e.stop)
+	e.free()
+	for _, w := range e.workers {
+		w.client.Close()
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// newTestOperations returns a server with nothing running and no workers, that does not save checkpoints.
+func newTestOperations() *GolOperations {
+	return &GolOperations{sessions: map[string]*session{}, maxSessions: 4, strips: map[int]*strip{}, shutdown: make(chan struct{})}
+}
+
+// serve answers RPC calls to ops on a local port until the test ends, passing each connection through wrap.
+func serve(t *testing.T, ops *GolOperations, wrap func(net.Conn) net.Conn) net.Listener {
+	server := rpc.NewServer()
+	if err := server.Register(ops); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeConn(wrap(conn))
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return listener
+}
+
+// testWorker is a worker served in process, whose connections can be cut and whose answers can be held back
+// to make it look stalled.
+type testWorker struct {
+	address  string
+	listener net.Listener
+	mu       sync.Mutex
+	cond     *sync.Cond
+	stalled  bool
+	conns    []net.Conn
+}
+
+// startWorkers starts n workers, stopping them when the test ends.
+func startWorkers(t *testing.T, n int) []*testWorker {
+	workers := make([]*testWorker, n)
+	for i := range workers {
+		w := new(testWorker)
+		w.cond = sync.NewCond(&w.mu)
+		w.listener = serve(t, newTestOperations(), func(conn net.Conn) net.Conn {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			w.conns = append(w.conns, conn)
+			return stallingConn{conn, w}
+		})
+		w.address = w.listener.Addr().String()
+		t.Cleanup(w.cut)
+		workers[i] = w
+	}
+	return workers
+}
+
+// addresses lists the addresses of workers.
+func addresses(workers []*testWorker) []string {
+	var addresses []string
+	for _, w := range workers {
+		addresses = append(addresses, w.address)
+	}
+	return addresses
+}
+
+// stall holds back every answer from the worker until it is unstalled.
+func (w *testWorker) stall(stalled bool) {
+	w.mu.Lock()
+	w.stalled = stalled
+	w.cond.Broadcast()
+	w.mu.Unlock()
+}
+
+// cut stops the worker and hangs up on everyone connected to it.
+func (w *testWorker) cut() {
+	w.listener.Close()
+	w.stall(false)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, conn := range w.conns {
+		conn.Close()
+	}
+}
+
+// stallingConn is a connection to a testWorker, whose writes wait while the worker is stalled.
+type stallingConn struct {
+	net.Conn
+	w *testWorker
+}
+
+func (c stallingConn) Write(b []byte) (int, error) {
+	c.w.mu.Lock()
+	for c.w.stalled {
+		c.w.cond.Wait()
+	}
+	c.w.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+// TestLostWorkers tests that a run carries on with the right world when one worker hangs up and another stops
+// answering heartbeats, with their strips split between the workers that are left, and that the stalled worker
+// rejoins the pool by registering again.
+func TestLostWorkers(t *testing.T) {
+	broker := newTestOperations()
+	brokerAddress := serve(t, broker, func(conn net.Conn) net.Conn { return conn }).Addr().String()
+	workers := startWorkers(t, 3)
+	broker.workers = addresses(workers)
+
+	rng := rand.New(rand.NewSource(4))
+	world := randomWorld(rng, stubs.Life, 32, 24)
+	req := stubs.Request{ImageWidth: 32, ImageHeight: 24, Threads: 2, Rule: stubs.Life}
+	var warnings []string
+	e, err := broker.newRemoteEngine(req, world, broker.pool(), func(warning string) { warnings = append(warnings, warning) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.close()
+
+	want := world
+	run := func(turns int) {
+		for turn := 0; turn < turns; turn++ {
+			want = referenceStep(stubs.Life, stubs.BoundaryTorus, want)
+			if _, _, err := e.step(false); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := e.world()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("turn %v on %v workers differs from the reference", e.turn, len(e.workers))
+		}
+	}
+
+	run(3)
+	workers[0].cut()
+	run(3)
+	workers[1].stall(true)
+	run(3)
+	if len(e.workers) != 1 || len(e.strips) != 1 || e.strips[0].worker.address != workers[2].address {
+		t.Errorf("run is on %v workers with %v strips, want only %v", len(e.workers), len(e.strips), workers[2].address)
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[0], workers[0].address) || !strings.Contains(warnings[1], workers[1].address) {
+		t.Errorf("warnings %q, want one for each lost worker", warnings)
+	}
+	if pool := broker.pool(); fmt.Sprint(pool) != fmt.Sprint([]string{workers[2].address}) {
+		t.Errorf("pool is %v once two workers are lost, want only %v", pool, workers[2].address)
+	}
+
+	workers[1].stall(false)
+	stop := make(chan struct{})
+	defer close(stop)
+	go keepRegistered(brokerAddress, workers[1].address, 10*time.Millisecond, stop)
+	for deadline := time.Now().Add(5 * time.Second); len(broker.pool()) != 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool is %v, want %v to have registered again", broker.pool(), workers[1].address)
+		}
+	}
+}
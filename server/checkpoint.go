@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// checkpointExt is the extension of the files checkpoints are written to, one per session.
+const checkpointExt = ".checkpoint"
+
+var errNoCheckpoint = errors.New("no checkpoint to resume")
+
+// checkpoint is a session's world and progress as written to disk, along with what is needed to carry on the run.
+type checkpoint struct {
+	Session        string
+	Owner          string
+	CompletedTurns int
+	Turns          int
+	ImageWidth     int
+	ImageHeight    int
+	Threads        int
+	Rule           stubs.Rule
+	Boundary       stubs.Boundary
+	Engine         stubs.Engine
+	Cycles         stubs.Cycles
+	World          stubs.Packed
+	Saved          time.Time
+}
+
+// request rebuilds the request that started the checkpointed run.
+func (c checkpoint) request() stubs.Request {
+	return stubs.Request{
+		Version:     stubs.RequestVersion,
+		Owner:       c.Owner,
+		World:       c.World,
+		ImageHeight: c.ImageHeight,
+		ImageWidth:  c.ImageWidth,
+		Turns:       c.Turns,
+		Threads:     c.Threads,
+		Rule:        c.Rule,
+		Boundary:    c.Boundary,
+		Engine:      c.Engine,
+		Cycles:      c.Cycles,
+	}
+}
+
+func checkpointPath(dir, id string) string {
+	return filepath.Join(dir, id+checkpointExt)
+}
+
+// writeCheckpoint saves c to dir, replacing the session's previous checkpoint only once the new one is complete.
+func writeCheckpoint(dir string, c checkpoint) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	path := checkpointPath(dir, c.Session)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(file).Encode(c)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func readCheckpoint(path string) (checkpoint, error) {
+	var c checkpoint
+	file, err := os.Open(path)
+	if err != nil {
+		return c, err
+	}
+	defer file.Close()
+	err = gob.NewDecoder(file).Decode(&c)
+	return c, err
+}
+
+// loadCheckpoint reads the checkpoint of session id from dir. An empty id picks the most recently saved checkpoint.
+func loadCheckpoint(dir, id string) (checkpoint, error) {
+	if id != "" {
+		c, err := readCheckpoint(checkpointPath(dir, id))
+		if errors.Is(err, os.ErrNotExist) {
+			return c, errNoCheckpoint
+		}
+		return c, err
+	}
+
+	var latest checkpoint
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return latest, err
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), checkpointExt) {
+			continue
+		}
+		c, err := readCheckpoint(filepath.Join(dir, entry.Name()))
+		if err == nil && c.Saved.After(latest.Saved) {
+			latest = c
+		}
+	}
+	if latest.Session == "" {
+		return latest, errNoCheckpoint
+	}
+	return latest, nil
+}
+
+func removeCheckpoint(dir, id string) {
+	os.Remove(checkpointPath(dir, id))
+}
+
+// checkpoint saves the session to disk if checkpointInterval has passed since it was last saved.
+// Only failing to read the world back from the engine is fatal to the run.
+func (ss *session) checkpoint(turn int) error {
+	if ss.checkpoints == "" || time.Since(ss.checkpointAt) < ss.checkpointInterval {
+		return nil
+	}
+	ss.checkpointAt = time.Now()
+	world, err := ss.engine.world()
+	if err != nil {
+		return err
+	}
+	err = writeCheckpoint(ss.checkpoints, checkpoint{
+		Session:        ss.id,
+		Owner:          ss.owner,
+		CompletedTurns: turn,
+		Turns:          ss.turns,
+		ImageWidth:     ss.width,
+		ImageHeight:    ss.height,
+		Threads:        ss.threads,
+		Rule:           ss.rule,
+		Boundary:       ss.boundary,
+		Engine:         ss.engineKind,
+		Cycles:         ss.cycles,
+		World:          stubs.Pack(world),
+		Saved:          ss.checkpointAt,
+	})
+	if err != nil {
+		ss.warn(fmt.Sprintf("Could not write a checkpoint at turn %v: %v", turn, err))
+	}
+	return nil
+}
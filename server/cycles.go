@@ -0,0 +1,93 @@
+package main
+
+// cycleHistory is how many turns back a session looks for a world the same as the current one,
+// and so the longest period of cycle it can find.
+const cycleHistory = 1 << 16
+
+// rowHash hashes the cells of a row with FNV-1a.
+func rowHash(row []uint8) uint64 {
+	hash := uint64(14695981039346656037)
+	for _, cell := range row {
+		hash ^= uint64(cell)
+		hash *= 1099511628211
+	}
+	return hash
+}
+
+// placeRow mixes in where a row lies in the world. A world hashes to the sum of the placed hashes of
+// its rows, so that strips of the world can be hashed separately, however the world is split.
+func placeRow(y int, hash uint64) uint64 {
+	hash += uint64(y) * 0x9e3779b97f4a7c15
+	hash = (hash ^ hash>>30) * 0xbf58476d1ce4e5b9
+	hash = (hash ^ hash>>27) * 0x94d049bb133111eb
+	return hash ^ hash>>31
+}
+
+func worldHash(world [][]uint8) uint64 {
+	var hash uint64
+	for y, row := range world {
+		hash += placeRow(y, rowHash(row))
+	}
+	return hash
+}
+
+// history remembers the hashes of the worlds reached in the last cycleHistory turns,
+// so that the first turn to repeat one of them can be spotted.
+type history struct {
+	turns map[uint64]int
+	// hashes holds the remembered hashes in a ring, starting from the oldest, so that they are forgotten in order.
+	hashes []uint64
+	oldest int
+	// candidate is the world last found to share its hash with an earlier one, until it is confirmed or ruled out.
+	candidate *candidate
+}
+
+// candidate is a world whose hash matched the world reached at an earlier turn. As hashes can collide,
+// the cycle is only trusted once the world a period later is found to be the same as it, cell by cell.
+type candidate struct {
+	world   [][]uint8
+	hash    uint64
+	turn    int
+	earlier int
+}
+
+func newHistory() *history {
+	return &history{turns: make(map[uint64]int)}
+}
+
+// see records the hash of the world reached at turn. If the world was reached before within cycleHistory turns,
+// it returns the turn it was first reached at instead.
+func (h *history) see(turn int, hash uint64) (int, bool) {
+	if earlier, ok := h.turns[hash]; ok {
+		return earlier, true
+	}
+	if len(h.hashes) < cycleHistory {
+		h.hashes = append(h.hashes, hash)
+	} else {
+		delete(h.turns, h.hashes[h.oldest])
+		h.hashes[h.oldest] = hash
+		h.oldest = (h.oldest + 1) % cycleHistory
+	}
+	h.turns[hash] = turn
+	return 0, false
+}
+
+// replace remembers hash as first reached at turn, once the world that was first reached with it has turned out
+// to be a different one, so that later repeats of the world at turn are matched with the right turn.
+func (h *history) replace(hash uint64, turn int) {
+	if _, ok := h.turns[hash]; ok {
+		h.turns[hash] = turn
+	}
+}
+
+func sameWorld(a, b [][]uint8) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for y := range a {
+		if string(a[y]) != string(b[y]) {
+			return false
+		}
+	}
+	return true
+}
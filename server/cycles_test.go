@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// TestCycles tests that sessions find still lifes and oscillators, and that CyclesStop skips to the world
+// the last turn would have reached.
+func TestCycles(t *testing.T) {
+	tests := []struct {
+		name   string
+		cells  [][2]int
+		start  int
+		period int
+	}{
+		{"block", [][2]int{{2, 2}, {3, 2}, {2, 3}, {3, 3}}, 0, 1},
+		{"blinker", [][2]int{{2, 3}, {3, 3}, {4, 3}}, 0, 2},
+		{"tromino", [][2]int{{2, 2}, {3, 2}, {2, 3}}, 1, 1},
+		{"glider", [][2]int{{1, 0}, {2, 1}, {0, 2}, {1, 2}, {2, 2}}, 0, 32},
+	}
+	engines := []stubs.Engine{stubs.EngineCells, stubs.EngineSparse, stubs.EngineBitboard, stubs.EngineHashLife}
+	for _, test := range tests {
+		world := make([][]uint8, 8)
+		for y := range world {
+			world[y] = make([]uint8, 8)
+		}
+		for _, c := range test.cells {
+			world[c[1]][c[0]] = 255
+		}
+		for _, cycles := range []stubs.Cycles{stubs.CyclesDetect, stubs.CyclesStop} {
+			turns := 100
+			if cycles == stubs.CyclesStop {
+				turns = 1000003
+			}
+			// The last turn reaches the same world as the first turn of the cycle a whole number of periods before it.
+			want := world
+			for turn := 0; turn < 100 && turn < turns; turn++ {
+				if turn >= test.start && (turns-turn)%test.period == 0 {
+					break
+				}
+				want = referenceStep(stubs.Life, stubs.BoundaryTorus, want)
+			}
+
+			for _, engine := range engines {
+				s := &GolOperations{sessions: map[string]*session{}, maxSessions: 1, strips: map[int]*strip{}, shutdown: make(chan struct{})}
+				req := stubs.Request{Version: stubs.RequestVersion, World: stubs.Pack(world), ImageWidth: 8, ImageHeight: 8,
+					Turns: turns, Threads: 2, Engine: engine, Cycles: cycles}
+				var res stubs.Response
+				if err := s.Start(req, &res); err != nil {
+					t.Fatalf("%v %v %v: %v", test.name, engine, cycles, err)
+				}
+				if err := s.Await(stubs.ControlRequest{Session: res.Session}, &res); err != nil {
+					t.Fatalf("%v %v %v: %v", test.name, engine, cycles, err)
+				}
+				if res.CycleStart != test.start || res.CyclePeriod != test.period {
+					t.Errorf("%v %v %v: cycle from turn %v every %v turns, want from %v every %v",
+						test.name, engine, cycles, res.CycleStart, res.CyclePeriod, test.start, test.period)
+				}
+				if res.CompletedTurns != turns || fmt.Sprint(res.FinalWorld.Unpack()) != fmt.Sprint(want) {
+					t.Errorf("%v %v %v: ended at turn %v with the wrong world, want turn %v", test.name, engine, cycles, res.CompletedTurns, turns)
+				}
+			}
+		}
+	}
+}
+
+// collidingEngine steps like the cells engine, but hashes every world the same, as if every hash collided.
+type collidingEngine struct {
+	*localEngine
+}
+
+func (collidingEngine) hash() (uint64, error) {
+	return 0, nil
+}
+
+// TestCycleCollisions tests that worlds that only share a hash are not taken for a cycle, so that CyclesStop
+// never skips to the wrong world, and that a real cycle is still found among them.
+func TestCycleCollisions(t *testing.T) {
+	tests := []struct {
+		name   string
+		cells  [][2]int
+		period int
+	}{
+		{"glider", [][2]int{{1, 0}, {2, 1}, {0, 2}, {1, 2}, {2, 2}}, 32},
+		{"blinker", [][2]int{{2, 3}, {3, 3}, {4, 3}}, 2},
+	}
+	for _, test := range tests {
+		world := make([][]uint8, 8)
+		for y := range world {
+			world[y] = make([]uint8, 8)
+		}
+		for _, c := range test.cells {
+			world[c[1]][c[0]] = 255
+		}
+		want := world
+		for turn := 0; turn < 1001; turn++ {
+			want = referenceStep(stubs.Life, stubs.BoundaryTorus, want)
+		}
+
+		req := stubs.Request{ImageWidth: 8, ImageHeight: 8, Turns: 1001, Threads: 1, Rule: stubs.Life, Cycles: stubs.CyclesStop}
+		ss := newSession(test.name, req, len(test.cells))
+		ss.engine = collidingEngine{newLocalEngine(req, world)}
+		ss.run()
+		if ss.err != nil {
+			t.Fatalf("%v: %v", test.name, ss.err)
+		}
+		if start, period := ss.cycle(); period != test.period {
+			t.Errorf("%v: cycle every %v turns from turn %v, want every %v", test.name, period, start, test.period)
+		}
+		if fmt.Sprint(ss.final) != fmt.Sprint(want) {
+			t.Errorf("%v: ended with the wrong world", test.name)
+		}
+	}
+}
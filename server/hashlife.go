@@ -0,0 +1,303 @@
+package main
+
+import (
+	"errors"
+	"math/bits"
+	"time"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// maxNodes is how many distinct nodes a hashLife engine keeps before it forgets every memoised result
+// and starts again from the current world.
+const maxNodes = 1 << 20
+
+// leapTarget is how long a single leap should take, so that controls are never kept waiting for long.
+// Leaps double in length while they take less than this and halve while they take more than twice as long.
+const leapTarget = 100 * time.Millisecond
+
+// node is a square of cells 2^level across, made of four quadrants a level down. Nodes are interned,
+// so equal squares are the same node and what a node evolves into can be remembered on the node itself.
+type node struct {
+	nw, ne, sw, se *node
+	level          int
+	// population counts the alive cells in the node. It overflows for the largest nodes, which are never asked.
+	population int
+	// results[j] is the centre of the node, half as wide, 2^j turns later.
+	results []*node
+}
+
+// quad is the key nodes are interned by.
+type quad struct {
+	nw, ne, sw, se *node
+}
+
+// hashLife evolves a torus as a quadtree, leaping ahead by powers of two turns at once. The world,
+// repeated to fill a square tile 2^tileLevel across, is treated as one tile of an infinite plane of
+// them, so that the centre of a square of tiles evolves just as the torus does.
+type hashLife struct {
+	rule      *lifeRule
+	width     int
+	height    int
+	dead      *node
+	alive     *node
+	nodes     map[quad]*node
+	tile      *node
+	tileLevel int
+	// leapLevel is the log2 of the most turns the next call to leap may advance.
+	leapLevel int
+}
+
+// errHashLife is returned for runs the hashLife engine cannot evolve.
+var errHashLife = errors.New("the hashlife engine only runs two-state rules in B/S notation on the Moore neighbourhood, " +
+	"on a torus whose sides are powers of two")
+
+// checkHashLife reports whether req can be run by the hashLife engine.
+func checkHashLife(req stubs.Request) error {
+	powerOfTwo := func(n int) bool { return n > 0 && n&(n-1) == 0 }
+	rule := newLifeRule(req.Rule)
+	if req.Boundary != stubs.BoundaryTorus || rule.wide || rule.generations ||
+		!powerOfTwo(req.ImageWidth) || !powerOfTwo(req.ImageHeight) {
+		return errHashLife
+	}
+	return nil
+}
+
+func newHashLife(req stubs.Request, world [][]uint8) *hashLife {
+	h := &hashLife{rule: newLifeRule(req.Rule), width: req.ImageWidth, height: req.ImageHeight}
+	h.reset()
+	size := h.width
+	if h.height > size {
+		size = h.height
+	}
+	h.tileLevel = bits.Len(uint(size)) - 1
+	h.tile = h.build(h.tileLevel, 0, 0, world)
+	h.leapLevel = h.tileLevel
+	return h
+}
+
+// reset forgets every node, keeping only the two single cells.
+func (h *hashLife) reset() {
+	h.nodes = make(map[quad]*node)
+	h.dead = &node{}
+	h.alive = &node{population: 1}
+}
+
+// join interns the node made of the four quadrants given.
+func (h *hashLife) join(nw, ne, sw, se *node) *node {
+	key := quad{nw, ne, sw, se}
+	if n, ok := h.nodes[key]; ok {
+		return n
+	}
+	n := &node{nw: nw, ne: ne, sw: sw, se: se, level: nw.level + 1,
+		population: nw.population + ne.population + sw.population + se.population}
+	h.nodes[key] = n
+	return n
+}
+
+// build interns the square of world 2^level across with its top left corner at (x, y), wrapping round the torus.
+func (h *hashLife) build(level, x, y int, world [][]uint8) *node {
+	if level == 0 {
+		if world[y%h.height][x%h.width] == 255 {
+			return h.alive
+		}
+		return h.dead
+	}
+	half := 1 << (level - 1)
+	return h.join(
+		h.build(level-1, x, y, world), h.build(level-1, x+half, y, world),
+		h.build(level-1, x, y+half, world), h.build(level-1, x+half, y+half, world))
+}
+
+// intern copies n, made by an earlier generation of nodes, into the current one.
+func (h *hashLife) intern(n *node) *node {
+	if n.level == 0 {
+		if n.population == 1 {
+			return h.alive
+		}
+		return h.dead
+	}
+	return h.join(h.intern(n.nw), h.intern(n.ne), h.intern(n.sw), h.intern(n.se))
+}
+
+// centre returns the middle of n, half as wide.
+func (h *hashLife) centre(n *node) *node {
+	return h.join(n.nw.se, n.ne.sw, n.sw.ne, n.se.nw)
+}
+
+// evolve returns the centre of n, a node at least 4 cells across, 2^j turns later, where j is at most n.level-2.
+func (h *hashLife) evolve(n *node, j int) *node {
+	if n.results == nil {
+		n.results = make([]*node, n.level-1)
+	}
+	if result := n.results[j]; result != nil {
+		return result
+	}
+
+	var result *node
+	if n.level == 2 {
+		result = h.evolveSmall(n)
+	} else {
+		// Nine overlapping squares half as wide as n, each a quarter of the way along from the last.
+		n00, n01, n02 := n.nw, h.join(n.nw.ne, n.ne.nw, n.nw.se, n.ne.sw), n.ne
+		n10 := h.join(n.nw.sw, n.nw.se, n.sw.nw, n.sw.ne)
+		n11 := h.centre(n)
+		n12 := h.join(n.ne.sw, n.ne.se, n.se.nw, n.se.ne)
+		n20, n21, n22 := n.sw, h.join(n.sw.ne, n.se.nw, n.sw.se, n.se.sw), n.se
+
+		// Their centres, moved on by half the turns if n is to leap as far as it can, and otherwise not at all.
+		step := func(m *node) *node {
+			if j == n.level-2 {
+				return h.evolve(m, j-1)
+			}
+			return h.centre(m)
+		}
+		c00, c01, c02 := step(n00), step(n01), step(n02)
+		c10, c11, c12 := step(n10), step(n11), step(n12)
+		c20, c21, c22 := step(n20), step(n21), step(n22)
+
+		// The remaining turns are made by the four squares those centres make up.
+		next := j
+		if j == n.level-2 {
+			next = j - 1
+		}
+		result = h.join(
+			h.evolve(h.join(c00, c01, c10, c11), next), h.evolve(h.join(c01, c02, c11, c12), next),
+			h.evolve(h.join(c10, c11, c20, c21), next), h.evolve(h.join(c11, c12, c21, c22), next))
+	}
+	n.results[j] = result
+	return result
+}
+
+// evolveSmall steps the centre 2x2 cells of a 4x4 node once.
+func (h *hashLife) evolveSmall(n *node) *node {
+	var cells [4][4]bool
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			quadrant := [2][2]*node{{n.nw, n.ne}, {n.sw, n.se}}[y/2][x/2]
+			cell := [2][2]*node{{quadrant.nw, quadrant.ne}, {quadrant.sw, quadrant.se}}[y%2][x%2]
+			cells[y][x] = cell.population == 1
+		}
+	}
+	next := func(x, y int) *node {
+		sum := 0
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				if (dx != 0 || dy != 0) && cells[y+dy][x+dx] {
+					sum++
+				}
+			}
+		}
+		level := uint8(0)
+		if cells[y][x] {
+			level = 255
+		}
+		if h.rule.next(level, h.rule.birth&(1<<sum) != 0, h.rule.survival&(1<<sum) != 0) == 255 {
+			return h.alive
+		}
+		return h.dead
+	}
+	return h.join(next(1, 1), next(2, 1), next(1, 2), next(2, 2))
+}
+
+// advance moves the world on by 2^j turns.
+func (h *hashLife) advance(j int) {
+	// A square of tiles wide enough that its centre, after 2^j turns, still holds a whole tile.
+	plane := h.tile
+	for plane.level < j+2 || plane.level < h.tileLevel+2 {
+		plane = h.join(plane, plane, plane, plane)
+	}
+	// The centre starts a whole number of tiles in, so its top left tile lines up with the torus.
+	result := h.evolve(plane, j)
+	for result.level > h.tileLevel {
+		result = result.nw
+	}
+	h.tile = result
+
+	if len(h.nodes) > maxNodes {
+		old := h.tile
+		h.reset()
+		h.tile = h.intern(old)
+	}
+}
+
+// aliveCells counts the alive cells in the world, which the tile may repeat.
+func (h *hashLife) aliveCells() int {
+	size := 1 << h.tileLevel
+	return h.tile.population * h.width * h.height / (size * size)
+}
+
+func (h *hashLife) step(track bool) (int, flips, error) {
+	before := h.tile
+	h.advance(0)
+	var flipped flips
+	if track {
+		h.diff(before, h.tile, 0, 0, &flipped)
+	}
+	return h.aliveCells(), flipped, nil
+}
+
+// diff adds the cells of the world that differ between a and b, two squares with their top left corner at (x, y).
+// Squares that have not changed are the same node, so only the parts of the world that have changed are visited.
+func (h *hashLife) diff(a, b *node, x, y int, flipped *flips) {
+	if a == b || x >= h.width || y >= h.height {
+		return
+	}
+	if a.level == 0 {
+		if a.population != b.population {
+			flipped.cells = append(flipped.cells, util.Cell{X: x, Y: y})
+		}
+		return
+	}
+	half := 1 << (a.level - 1)
+	h.diff(a.nw, b.nw, x, y, flipped)
+	h.diff(a.ne, b.ne, x+half, y, flipped)
+	h.diff(a.sw, b.sw, x, y+half, flipped)
+	h.diff(a.se, b.se, x+half, y+half, flipped)
+}
+
+// leap advances as many turns as it can up to turns, in a single power of two no longer than recent leaps
+// have shown can be made in about leapTarget. It returns how many turns it advanced and the alive cells left.
+func (h *hashLife) leap(turns int) (int, int, error) {
+	j := bits.Len(uint(turns)) - 1
+	if j > h.leapLevel {
+		j = h.leapLevel
+	}
+	start := time.Now()
+	h.advance(j)
+	switch took := time.Since(start); {
+	case took < leapTarget && j == h.leapLevel:
+		h.leapLevel++
+	case took > 2*leapTarget && h.leapLevel > 0:
+		h.leapLevel--
+	}
+	return 1 << j, h.aliveCells(), nil
+}
+
+func (h *hashLife) world() ([][]uint8, error) {
+	world := make([][]uint8, h.height)
+	for y := range world {
+		world[y] = make([]uint8, h.width)
+	}
+	h.fill(h.tile, 0, 0, world)
+	return world, nil
+}
+
+// fill sets the alive cells of n, with its top left corner at (x, y), in world.
+func (h *hashLife) fill(n *node, x, y int, world [][]uint8) {
+	if n.population == 0 || x >= h.width || y >= h.height {
+		return
+	}
+	if n.level == 0 {
+		world[y][x] = 255
+		return
+	}
+	half := 1 << (n.level - 1)
+	h.fill(n.nw, x, y, world)
+	h.fill(n.ne, x+half, y, world)
+	h.fill(n.sw, x, y+half, world)
+	h.fill(n.se, x+half, y+half, world)
+}
+
+func (h *hashLife) close() {
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// localEngine steps the whole world on this machine, splitting each turn across goroutines.
+type localEngine struct {
+	rule     *lifeRule
+	topology *topology
+	height   int
+	width    int
+	threads  int
+	current  [][]uint8
+	next     [][]uint8
+}
+
+func newLocalEngine(req stubs.Request, world [][]uint8) *localEngine {
+	next := make([][]uint8, req.ImageHeight)
+	for i := range next {
+		next[i] = make([]uint8, req.ImageWidth)
+	}
+	return &localEngine{
+		rule:     newLifeRule(req.Rule),
+		topology: newTopology(req.Boundary, req.ImageWidth),
+		height:   req.ImageHeight,
+		width:    req.ImageWidth,
+		threads:  req.Threads,
+		current:  world,
+		next:     next,
+	}
+}
+
+func (e *localEngine) step(track bool) (int, flips, error) {
+	var flipped flips
+	var tracked *flips
+	if track {
+		tracked = &flipped
+	}
+	alive := calculateNextState(e.rule, e.topology, e.height, e.width, e.threads, e.current, e.next, tracked)
+	e.current, e.next = e.next, e.current
+	return alive, flipped, nil
+}
+
+func (e *localEngine) world() ([][]uint8, error) {
+	world := make([][]uint8, e.height)
+	for y := range world {
+		world[y] = append([]uint8(nil), e.current[y]...)
+	}
+	return world, nil
+}
+
+func (e *localEngine) close() {
+}
+
+func (e *localEngine) hash() (uint64, error) {
+	return worldHash(e.current), nil
+}
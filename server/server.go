@@ -1,10 +1,15 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"log"
 	"math/rand"
 	"net"
 	"net/rpc"
+	"sort"
+	"sync"
 	"time"
 	"uk.ac.bris.cs/gameoflife/stubs"
 	"uk.ac.bris.cs/gameoflife/util"
@@ -15,68 +20,735 @@ func calculateAliveCells(imageHeight, imageWidth int, world [][]byte) []util.Cel
 	for y := 0; y < imageHeight; y++ {
 		for x := 0; x < imageWidth; x++ {
 			if world[y][x] == 255 {
-				aliveCells = append(aliveCells, util.Cell{x, y})
+				aliveCells = append(aliveCells, util.Cell{X: x, Y: y})
 			}
 		}
 	}
 	return aliveCells
 }
 
-func calculateNextState(imageHeight, imageWidth int, world, resultWorld [][]uint8) {
-	/*resultWorld := make([][]byte, imageHeight)
-	for i := range resultWorld {
-		resultWorld[i] = make([]byte, imageWidth)
-	}*/
-	//var sum int
-	for y := 0; y < imageHeight; y++ {
-		for x := 0; x < imageWidth; x++ {
-			sum := (world[(y+imageHeight-1)%imageHeight][(x+imageWidth-1)%imageWidth] / 255) + (world[(y+imageHeight-1)%imageHeight][(x+imageWidth)%imageWidth] / 255) +
-				(world[(y+imageHeight-1)%imageHeight][(x+imageWidth+1)%imageWidth] / 255) + (world[(y+imageHeight)%imageHeight][(x+imageWidth-1)%imageWidth] / 255) +
-				(world[(y+imageHeight)%imageHeight][(x+imageWidth+1)%imageWidth] / 255) + (world[(y+imageHeight+1)%imageHeight][(x+imageWidth-1)%imageWidth] / 255) +
-				(world[(y+imageHeight+1)%imageHeight][(x+imageWidth)%imageWidth] / 255) + (world[(y+imageHeight+1)%imageHeight][(x+imageWidth+1)%imageWidth] / 255)
-			if world[y][x] == 255 {
-				if sum < 2 {
-					resultWorld[y][x] = 0
-				} else if sum == 2 || sum == 3 {
-					resultWorld[y][x] = 255
-				} else {
-					resultWorld[y][x] = 0
-				}
-			} else {
-				if sum == 3 {
-					resultWorld[y][x] = 255
-				} else {
-					resultWorld[y][x] = 0
-				}
+// lifeRule is a stubs.Rule prepared for stepping the world.
+type lifeRule struct {
+	birth    uint16
+	survival uint16
+	// decay maps the grey level of a cell that is alive or dying but not surviving to its level in the next turn.
+	decay [256]uint8
+	// generations is set for rules with dying states, whose flipped cells must be sent with their new levels.
+	generations bool
+
+	// radius is the number of rows each side of a row that are needed to step it.
+	radius int
+	// wide is set for every neighbourhood but the Moore neighbourhood of radius 1. Such rules are stepped
+	// by calculateNextRowWide, which looks up born and survives by neighbour count, and counts in each of
+	// the 2*radius+1 rows around a cell the columns spans gives relative to it.
+	wide     bool
+	born     []bool
+	survives []bool
+	spans    [][2]int
+}
+
+func newLifeRule(r stubs.Rule) *lifeRule {
+	r = r.OrLife()
+	rule := &lifeRule{birth: r.Birth, survival: r.Survival, generations: r.Generations(), radius: r.Radius()}
+	if r.LargerThanLife() || r.Neighbourhood != stubs.NeighbourhoodMoore {
+		rule.wide = true
+		for n := 0; n <= r.Neighbourhood.Size(rule.radius); n++ {
+			rule.born = append(rule.born, r.Born(n))
+			rule.survives = append(rule.survives, r.Survives(n))
+		}
+		for dy := -rule.radius; dy <= rule.radius; dy++ {
+			lo, hi := r.Neighbourhood.Span(dy, rule.radius)
+			rule.spans = append(rule.spans, [2]int{lo, hi})
+		}
+	}
+	if !rule.generations {
+		return rule
+	}
+	// A level between two dying states decays to the lower one, so any grey level fades out in time.
+	for level := 1; level < 256; level++ {
+		for k := 2; k < r.States; k++ {
+			if int(r.Level(k)) < level {
+				rule.decay[level] = r.Level(k)
+				break
+			}
+		}
+	}
+	return rule
+}
+
+// next returns the level a cell at level moves to, given whether it would come alive if dead and stay alive if alive.
+func (rule *lifeRule) next(level uint8, born, survives bool) uint8 {
+	switch {
+	case level == 255 && survives, level == 0 && born:
+		return 255
+	case level == 0:
+		return 0
+	default:
+		return rule.decay[level]
+	}
+}
+
+// topology is a stubs.Boundary prepared for stepping a world of a given width.
+type topology struct {
+	boundary stubs.Boundary
+	width    int
+	// left and right are the columns standing in for those beyond the left and right edges, or -1 where they are dead.
+	left  int
+	right int
+	blank []uint8
+}
+
+func newTopology(b stubs.Boundary, width int) *topology {
+	t := &topology{boundary: b, width: width, blank: make([]uint8, width)}
+	t.left, t.right = t.column(-1), t.column(width)
+	return t
+}
+
+// column returns the column standing in for x, which may be up to a world's width beyond either edge,
+// or -1 if the cells there are dead.
+func (t *topology) column(x int) int {
+	if x >= 0 && x < t.width {
+		return x
+	}
+	switch t.boundary {
+	case stubs.BoundaryDead:
+		return -1
+	case stubs.BoundaryReflect:
+		if x < 0 {
+			return -x - 1
+		}
+		return 2*t.width - 1 - x
+	default:
+		return (x + t.width) % t.width
+	}
+}
+
+// beyond returns the rows standing in for those beyond the top or bottom edge of the world,
+// given as many rows along that edge and along the opposite edge. All rows are in world order.
+func (t *topology) beyond(edge, opposite [][]uint8) [][]uint8 {
+	rows := make([][]uint8, len(edge))
+	for i := range rows {
+		switch t.boundary {
+		case stubs.BoundaryDead, stubs.BoundaryCylinder:
+			rows[i] = t.blank
+		case stubs.BoundaryReflect:
+			rows[i] = edge[len(edge)-1-i]
+		case stubs.BoundaryKlein:
+			rows[i] = make([]uint8, len(opposite[i]))
+			for x, cell := range opposite[i] {
+				rows[i][len(rows[i])-1-x] = cell
+			}
+		default:
+			rows[i] = opposite[i]
+		}
+	}
+	return rows
+}
+
+// calculateNextRow computes the next state of the columns [startX, endX) of row under rule from the rows directly
+// above and below it and returns the number of alive cells in the result. Only cells at 255 count as alive neighbours.
+// The left and right edges are joined as topo says, so the world is only bounded in the vertical direction by the rows given.
+func calculateNextRow(rule *lifeRule, topo *topology, startX, endX int, above, row, below, resultRow []uint8) int {
+	alive := 0
+	for x := startX; x < endX; x++ {
+		left, right := x-1, x+1
+		if left < 0 {
+			left = topo.left
+		}
+		if right == topo.width {
+			right = topo.right
+		}
+		sum := (above[x] / 255) + (below[x] / 255)
+		if left >= 0 {
+			sum += (above[left] / 255) + (row[left] / 255) + (below[left] / 255)
+		}
+		if right >= 0 {
+			sum += (above[right] / 255) + (row[right] / 255) + (below[right] / 255)
+		}
+		resultRow[x] = rule.next(row[x], rule.birth&(1<<sum) != 0, rule.survival&(1<<sum) != 0)
+		if resultRow[x] == 255 {
+			alive++
+		}
+	}
+	return alive
+}
+
+// calculateNextRowWide is calculateNextRow for wide rules. rows holds the 2*radius+1 rows centred on the row
+// being stepped, and totals is scratch space for a running count of alive cells along each of them,
+// at least as long as a row plus 2*radius+1.
+func calculateNextRowWide(rule *lifeRule, topo *topology, startX, endX int, rows [][]uint8, resultRow []uint8, totals [][]int32) int {
+	r := rule.radius
+	// totals[i][c-startX+r+1] counts the alive cells of rows[i] in the columns [startX-r, c].
+	for i, row := range rows {
+		total := totals[i]
+		for c := startX - r; c < endX+r; c++ {
+			var cell int32
+			if x := topo.column(c); x >= 0 && row[x] == 255 {
+				cell = 1
+			}
+			total[c-startX+r+1] = total[c-startX+r] + cell
+		}
+	}
+
+	alive := 0
+	row := rows[r]
+	for x := startX; x < endX; x++ {
+		var sum int32
+		for i, span := range rule.spans {
+			sum += totals[i][x-startX+span[1]+r+1] - totals[i][x-startX+span[0]+r]
+		}
+		if row[x] == 255 {
+			sum--
+		}
+		resultRow[x] = rule.next(row[x], rule.born[sum], rule.survives[sum])
+		if resultRow[x] == 255 {
+			alive++
+		}
+	}
+	return alive
+}
+
+func countAliveCells(world [][]uint8) int {
+	alive := 0
+	for _, row := range world {
+		for _, cell := range row {
+			if cell == 255 {
+				alive++
+			}
+		}
+	}
+	return alive
+}
+
+// splitRows divides the rows [0, height) into contiguous bands and runs f on each band in its own goroutine,
+// returning the sum of their results once every band is done.
+func splitRows(height, threads int, f func(startY, endY int) int) int {
+	if threads < 1 {
+		threads = 1
+	}
+	if threads > height {
+		threads = height
+	}
+	results := make([]int, threads)
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		i := i
+		startY := i * height / threads
+		endY := (i + 1) * height / threads
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = f(startY, endY)
+		}()
+	}
+	wg.Wait()
+	sum := 0
+	for _, result := range results {
+		sum += result
+	}
+	return sum
+}
+
+// flips lists the cells that changed state in a turn. For Generations rules, where a changed cell
+// has not simply flipped between alive and dead, levels holds the grey level each cell changed to.
+type flips struct {
+	cells  []util.Cell
+	levels []uint8
+}
+
+// appendFlipped adds the cells of row y that changed state between row and resultRow,
+// along with their new levels if shaded is set.
+func (f *flips) appendFlipped(y int, row, resultRow []uint8, shaded bool) {
+	for x := range row {
+		if row[x] != resultRow[x] {
+			f.cells = append(f.cells, util.Cell{X: x, Y: y})
+			if shaded {
+				f.levels = append(f.levels, resultRow[x])
 			}
 		}
 	}
 }
 
+func (f *flips) append(other flips) {
+	f.cells = append(f.cells, other.cells...)
+	f.levels = append(f.levels, other.levels...)
+}
+
+// sort puts the cells in row order, the order stubs.Packed lists them in once they are on the wire.
+func (f *flips) sort() {
+	sort.Sort(f)
+}
+
+func (f *flips) Len() int {
+	return len(f.cells)
+}
+
+func (f *flips) Less(i, j int) bool {
+	a, b := f.cells[i], f.cells[j]
+	return a.Y < b.Y || a.Y == b.Y && a.X < b.X
+}
+
+func (f *flips) Swap(i, j int) {
+	f.cells[i], f.cells[j] = f.cells[j], f.cells[i]
+	if f.levels != nil {
+		f.levels[i], f.levels[j] = f.levels[j], f.levels[i]
+	}
+}
+
+// calculateNextState computes the next state of the whole world under rule, with its edges joined as topo says.
+// If flipped is not nil, the cells that changed state are added to it.
+func calculateNextState(rule *lifeRule, topo *topology, imageHeight, imageWidth, threads int, world, resultWorld [][]uint8, flipped *flips) int {
+	r := rule.radius
+	top := topo.beyond(world[:r], world[imageHeight-r:])
+	bottom := topo.beyond(world[imageHeight-r:], world[:r])
+	return calculateNextStrip(rule, topo, imageWidth, threads, top, bottom, world, resultWorld, flipped)
+}
+
 type GolOperations struct {
+	mu       sync.Mutex
+	workers  []string
+	strips   map[int]*strip
+	nextID   int
+	shutdown chan struct{}
+	once     sync.Once
+
+	// sessions holds the sessions that are running or have finished without being awaited.
+	// maxSessions is how many of them may be running at once.
+	sessions    map[string]*session
+	maxSessions int
+
+	// checkpoints is the directory sessions are saved to every checkpointInterval, or empty to not save them.
+	checkpoints        string
+	checkpointInterval time.Duration
+}
+
+// newEngine picks the engine req asks for. The cells engine steps the run across the worker pool
+// when there is one, and locally otherwise.
+func (s *GolOperations) newEngine(req stubs.Request, world [][]uint8, warn func(string)) engine {
+	switch req.Engine {
+	case stubs.EngineHashLife:
+		return newHashLife(req, world)
+	case stubs.EngineSparse:
+		return newSparseEngine(req, world)
+	case stubs.EngineBitboard:
+		return newBitboardEngine(req, world)
+	}
+	if workers := s.pool(); len(workers) > 0 {
+		e, err := s.newRemoteEngine(req, world, workers, warn)
+		if err == nil {
+			return e
+		}
+		log.Println("Running locally:", err)
+	}
+	return newLocalEngine(req, world)
+}
+
+// Start begins evolving req in the background as a new session, so that the run can be controlled,
+// detached from and reattached to before Await returns. Other sessions carry on alongside it.
+func (s *GolOperations) Start(req stubs.Request, res *stubs.Response) (err error) {
+	if req.Version != stubs.RequestVersion {
+		return fmt.Errorf("request version %v is not the supported version %v", req.Version, stubs.RequestVersion)
+	}
+	if req.World.Height != req.ImageHeight || req.World.Width != req.ImageWidth {
+		return fmt.Errorf("world is %vx%v but the request is for %vx%v", req.World.Width, req.World.Height, req.ImageWidth, req.ImageHeight)
+	}
+	if req.Boundary < stubs.BoundaryTorus || req.Boundary > stubs.BoundaryCylinder {
+		return fmt.Errorf("unknown boundary %v", req.Boundary)
+	}
+	if req.Cycles < stubs.CyclesOff || req.Cycles > stubs.CyclesStop {
+		return fmt.Errorf("unknown cycle detection %v", req.Cycles)
+	}
+	req.Rule = req.Rule.OrLife()
+	if r := req.Rule.Radius(); r > req.ImageWidth || r > req.ImageHeight {
+		return fmt.Errorf("rule %v reaches further than the %vx%v world", req.Rule, req.ImageWidth, req.ImageHeight)
+	}
+	switch req.Engine {
+	case stubs.EngineCells, stubs.EngineSparse:
+	case stubs.EngineHashLife:
+		if err = checkHashLife(req); err != nil {
+			return
+		}
+	case stubs.EngineBitboard:
+		if err = checkBitboard(req); err != nil {
+			return
+		}
+	default:
+		return fmt.Errorf("unknown engine %v", req.Engine)
+	}
+	ss, err := s.begin(fmt.Sprintf("%08x", rand.Uint32()), req, 0)
+	if err != nil {
+		return
+	}
+	res.Session = ss.id
+	res.CompletedTurns, res.AliveCells = ss.progress()
+	return
+}
+
+// begin runs req as the session id, picking up from turn. If the server is already running maxSessions
+// sessions, the one of the same owner's that has been detached from for longest is stopped to make room,
+// keeping its checkpoint so that it can be resumed. Other owners' sessions are never stopped: if none can be,
+// begin fails because the server is full.
+func (s *GolOperations) begin(id string, req stubs.Request, turn int) (*session, error) {
+	world := req.World.Unpack()
+	ss := newSession(id, req, countAliveCells(world))
+	ss.turn = turn
+	ss.checkpoints, ss.checkpointInterval = s.checkpoints, s.checkpointInterval
+
+	s.mu.Lock()
+	running, err := s.admit(id)
+	var evicted *session
+	if err == nil && len(running) >= s.maxSessions {
+		for _, other := range running {
+			if other.owner == req.Owner && other.isDetached() && s.checkpoints != "" &&
+				(evicted == nil || other.detachedAt().Before(evicted.detachedAt())) {
+				evicted = other
+			}
+		}
+		if evicted == nil {
+			err = fmt.Errorf("server full: already running %v sessions, none of them detached ones of yours", len(running))
+		} else {
+			delete(s.sessions, evicted.id)
+		}
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if evicted != nil {
+		log.Println("Stopping detached session", evicted.id, "to make room for session", id)
+		evicted.evict(errEvicted)
+	}
+
+	// The engine is built before the session is listed, so that List and CountAlive never see it half made.
+	// Another session may have taken the place made for this one in the meantime.
+	ss.engine = s.newEngine(req, world, ss.warn)
+	s.mu.Lock()
+	running, err = s.admit(id)
+	if err == nil && len(running) >= s.maxSessions {
+		err = fmt.Errorf("server full: already running %v sessions", len(running))
+	}
+	if err != nil {
+		s.mu.Unlock()
+		ss.engine.close()
+		return nil, err
+	}
+	s.sessions[id] = ss
+	s.mu.Unlock()
+
+	go ss.run()
+	return ss, nil
+}
+
+// admit checks that no session called id is running, and returns the sessions that are. It must be called with s.mu held.
+func (s *GolOperations) admit(id string) ([]*session, error) {
+	if _, ok := s.sessions[id]; ok {
+		return nil, fmt.Errorf("session %v is still running, attach to it instead", id)
+	}
+	var running []*session
+	for _, other := range s.sessions {
+		if !other.finished() {
+			running = append(running, other)
+		}
+	}
+	return running, nil
+}
+
+// Restore resumes a session from its latest checkpoint on disk, such as after the server has been restarted.
+// An empty session ID picks the most recently saved checkpoint. Like Attach, it returns the world the run
+// carries on from. Like Start, the run can be started paused so that the controller can set up first.
+func (s *GolOperations) Restore(req stubs.RestoreRequest, res *stubs.Response) (err error) {
+	if s.checkpoints == "" {
+		return errors.New("checkpoints are disabled on this server")
+	}
+	c, err := loadCheckpoint(s.checkpoints, req.Session)
+	if err != nil {
+		return
+	}
+	if c.ImageWidth != req.ImageWidth || c.ImageHeight != req.ImageHeight {
+		return fmt.Errorf("session %v is %vx%v, not %vx%v", c.Session, c.ImageWidth, c.ImageHeight, req.ImageWidth, req.ImageHeight)
+	}
+	run := c.request()
+	run.Stream, run.Paused = req.Stream, req.Paused
+	ss, err := s.begin(c.Session, run, c.CompletedTurns)
+	if err != nil {
+		return
+	}
+	res.Session = ss.id
+	res.CompletedTurns, res.AliveCells = ss.progress()
+	res.FinalWorld = c.World
+	return
+}
+
+// Await blocks until the session has finished and returns its final world. The session is forgotten
+// once the controller has also collected everything left in its stream.
+func (s *GolOperations) Await(req stubs.ControlRequest, res *stubs.Response) (err error) {
+	ss, err := s.lookup(req.Session)
+	if err != nil {
+		return
+	}
+	err = ss.await(res)
+	s.release(ss)
+	return
+}
+
+// release forgets ss if it is spent.
+func (s *GolOperations) release(ss *session) {
+	if !ss.spent() {
+		return
+	}
+	s.mu.Lock()
+	if s.sessions[ss.id] == ss {
+		delete(s.sessions, ss.id)
+	}
+	s.mu.Unlock()
+}
+
+// Evolve runs a version 1 request to completion in a single call, so that clients from before requests were
+// versioned keep working alongside newer ones. The world is stepped on one thread, as version 1 servers did.
+func (s *GolOperations) Evolve(req stubs.RequestV1, res *stubs.ResponseV1) (err error) {
+	if len(req.World) != req.ImageHeight {
+		return fmt.Errorf("world has %v rows but the request is for %vx%v", len(req.World), req.ImageWidth, req.ImageHeight)
+	}
+	for _, row := range req.World {
+		if len(row) != req.ImageWidth {
+			return fmt.Errorf("world has a row of %v cells but the request is for %vx%v", len(row), req.ImageWidth, req.ImageHeight)
+		}
+	}
+	var status stubs.Response
+	err = s.Start(stubs.Request{Version: stubs.RequestVersion, World: stubs.Pack(req.World),
+		ImageHeight: req.ImageHeight, ImageWidth: req.ImageWidth, Turns: req.Turns, Threads: 1}, &status)
+	if err != nil {
+		return
+	}
+	err = s.Await(stubs.ControlRequest{Session: status.Session}, &status)
+	if err != nil {
+		return
+	}
+	res.FinalWorld, res.CompletedTurns, res.AliveCells = status.FinalWorld.Unpack(), status.CompletedTurns, status.AliveCells
+	return
+}
+
+// lookup returns the session with the given id. An empty id refers to the only session, if there is just one.
+func (s *GolOperations) lookup(id string) (*session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id == "" && len(s.sessions) > 1 {
+		return nil, fmt.Errorf("%v sessions are running, choose one by its ID", len(s.sessions))
+	}
+	for _, ss := range s.sessions {
+		if id == "" || id == ss.id {
+			return ss, nil
+		}
+	}
+	return nil, errNoSession
 }
 
-func (s *GolOperations) Evolve(req stubs.Request, res *stubs.Response) (err error) {
-	world := req.World
-	newWorld := req.NewWorld
-	turn := 0
-	for turn < req.Turns {
-		calculateNextState(req.ImageHeight, req.ImageWidth, world, newWorld)
-		world, newWorld = newWorld, world
-		turn++
+// List describes every session on the server, oldest first.
+func (s *GolOperations) List(req stubs.ListRequest, res *stubs.ListResponse) (err error) {
+	s.mu.Lock()
+	for _, ss := range s.sessions {
+		res.Sessions = append(res.Sessions, ss.info())
 	}
-	res.CompletedTurns = turn
-	res.FinalWorld = world
+	s.mu.Unlock()
+	sort.Slice(res.Sessions, func(i, j int) bool { return res.Sessions[i].Started.Before(res.Sessions[j].Started) })
+	return
+}
+
+// Cancel stops a session and throws its world away. Only the session's owner may cancel it.
+// Its pending Await call fails rather than returning the world.
+func (s *GolOperations) Cancel(req stubs.ControlRequest, res *stubs.Response) (err error) {
+	ss, err := s.lookup(req.Session)
+	if err != nil {
+		return
+	}
+	if req.Owner != ss.owner {
+		return fmt.Errorf("session %v belongs to %v", ss.id, ss.owner)
+	}
+	res.CompletedTurns, res.AliveCells = ss.progress()
+	ss.cancel()
+	s.mu.Lock()
+	delete(s.sessions, ss.id)
+	s.mu.Unlock()
+	return
+}
+
+// Attach lets a new controller pick up a running session, returning a snapshot of its world.
+// Streaming restarts from the turn of the snapshot in the mode the new controller asked for.
+func (s *GolOperations) Attach(req stubs.ControlRequest, res *stubs.Response) (err error) {
+	ss, err := s.lookup(req.Session)
+	if err != nil {
+		return
+	}
+	var world [][]uint8
+	var worldErr error
+	err = ss.control(func() {
+		ss.setStream(req.Stream)
+		ss.setDetached(false)
+		res.Session = ss.id
+		res.Paused = ss.paused
+		res.CompletedTurns, res.AliveCells = ss.progress()
+		world, worldErr = ss.engine.world()
+	})
+	if err != nil {
+		return
+	}
+	res.FinalWorld = stubs.Pack(world)
+	return worldErr
+}
+
+// Detach stops streaming to the controller, leaving the session running without it.
+func (s *GolOperations) Detach(req stubs.ControlRequest, res *stubs.Response) (err error) {
+	ss, err := s.lookup(req.Session)
+	if err != nil {
+		return
+	}
+	return ss.control(func() {
+		ss.setStream(stubs.StreamOff)
+		ss.setDetached(true)
+		res.CompletedTurns, res.AliveCells = ss.progress()
+	})
+}
+
+// Flipped returns the cells flipped since the last call, for controllers that are rendering the run.
+func (s *GolOperations) Flipped(req stubs.ControlRequest, res *stubs.FlippedResponse) (err error) {
+	ss, err := s.lookup(req.Session)
+	if err != nil {
+		return
+	}
+	ss.flipped(res)
+	if res.Done {
+		s.release(ss)
+	}
+	return
+}
+
+func (s *GolOperations) CountAlive(req stubs.ControlRequest, res *stubs.Response) (err error) {
+	ss, err := s.lookup(req.Session)
+	if err != nil {
+		return
+	}
+	res.CompletedTurns, res.AliveCells = ss.progress()
+	res.Warnings = ss.takeWarnings()
+	res.CycleStart, res.CyclePeriod = ss.cycle()
+	if t, ok := ss.engine.(tiler); ok {
+		res.SkippedTiles = t.skippedTiles()
+	}
+	return
+}
+
+// Pause stops the run at the end of the current turn until Resume is called.
+func (s *GolOperations) Pause(req stubs.ControlRequest, res *stubs.Response) (err error) {
+	ss, err := s.lookup(req.Session)
+	if err != nil {
+		return
+	}
+	return ss.control(func() {
+		ss.setPaused(true)
+		res.Paused = true
+		res.CompletedTurns, res.AliveCells = ss.progress()
+	})
+}
+
+func (s *GolOperations) Resume(req stubs.ControlRequest, res *stubs.Response) (err error) {
+	ss, err := s.lookup(req.Session)
+	if err != nil {
+		return
+	}
+	return ss.control(func() {
+		ss.setPaused(false)
+		res.CompletedTurns, res.AliveCells = ss.progress()
+	})
+}
+
+// Save returns a snapshot of the world between two turns without stopping the run.
+func (s *GolOperations) Save(req stubs.ControlRequest, res *stubs.Response) (err error) {
+	ss, err := s.lookup(req.Session)
+	if err != nil {
+		return
+	}
+	var world [][]uint8
+	var worldErr error
+	err = ss.control(func() {
+		res.CompletedTurns, res.AliveCells = ss.progress()
+		world, worldErr = ss.engine.world()
+	})
+	if err != nil {
+		return
+	}
+	res.FinalWorld = stubs.Pack(world)
+	return worldErr
+}
+
+// Quit ends the run after the current turn. The pending Await call returns the world as it was then.
+func (s *GolOperations) Quit(req stubs.ControlRequest, res *stubs.Response) (err error) {
+	ss, err := s.lookup(req.Session)
+	if err != nil {
+		return
+	}
+	return ss.control(func() {
+		ss.quit = true
+		res.CompletedTurns, res.AliveCells = ss.progress()
+	})
+}
+
+// Shutdown stops every session in flight, shuts down every registered worker and then this process.
+// Sessions are stopped as if evicted, so whoever started them can resume them from their checkpoints
+// once the server is back.
+func (s *GolOperations) Shutdown(req stubs.ControlRequest, res *stubs.Response) (err error) {
+	s.mu.Lock()
+	sessions := s.sessions
+	s.sessions = make(map[string]*session)
+	s.mu.Unlock()
+	for _, ss := range sessions {
+		if !ss.finished() {
+			log.Println("Stopping session", ss.id, "of", ss.owner, "to shut down")
+		}
+		ss.evict(errShutDown)
+	}
+	for _, worker := range s.pool() {
+		client, err := rpc.Dial("tcp", worker)
+		if err != nil {
+			continue
+		}
+		_ = client.Call(stubs.ShutdownHandler, stubs.ControlRequest{}, new(stubs.Response))
+		client.Close()
+	}
+	s.once.Do(func() { close(s.shutdown) })
 	return
 }
 
 func main() {
 	pAddr := flag.String("port", "8030", "Port to listen on")
+	brokerAddr := flag.String("broker", "", "Address of the broker to register with as a worker. Leave empty to run as the broker.")
+	ip := flag.String("ip", "127.0.0.1", "IP address the broker should use to reach this worker")
+	checkpoints := flag.String("checkpoints", "checkpoints", "Directory to save running sessions to so they can be resumed. Leave empty to disable checkpoints.")
+	checkpointInterval := flag.Duration("checkpoint-interval", time.Minute, "How often to save each running session")
+	maxSessions := flag.Int("max-sessions", 4, "How many sessions may run at once")
 	flag.Parse()
 	rand.Seed(time.Now().UnixNano())
 
-	rpc.Register(&GolOperations{})
+	operations := &GolOperations{
+		sessions:           make(map[string]*session),
+		maxSessions:        *maxSessions,
+		strips:             make(map[int]*strip),
+		shutdown:           make(chan struct{}),
+		checkpoints:        *checkpoints,
+		checkpointInterval: *checkpointInterval,
+	}
+	rpc.Register(operations)
 	listener, _ := net.Listen("tcp", ":"+*pAddr)
 	defer listener.Close()
-	rpc.Accept(listener)
+
+	if *brokerAddr != "" {
+		err := register(*brokerAddr, *ip+":"+*pAddr)
+		if err != nil {
+			log.Fatal("registering:", err)
+		}
+		go keepRegistered(*brokerAddr, *ip+":"+*pAddr, registerInterval, operations.shutdown)
+	}
+	go rpc.Accept(listener)
+
+	<-operations.shutdown
+	// Give the reply to Shutdown a moment to reach the caller before exiting.
+	time.Sleep(100 * time.Millisecond)
 }
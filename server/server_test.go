@@ -0,0 +1,403 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// referenceStep steps world once under rule by counting the neighbours of each cell one at a time,
+// with the edges of the world joined as boundary says.
+func referenceStep(rule stubs.Rule, boundary stubs.Boundary, world [][]uint8) [][]uint8 {
+	height, width := len(world), len(world[0])
+	radius := rule.Radius()
+	abs := func(n int) int {
+		if n < 0 {
+			return -n
+		}
+		return n
+	}
+	// neighbour reports whether the cell dx, dy away from a cell is one of its neighbours.
+	neighbour := func(dx, dy int) bool {
+		switch rule.Neighbourhood {
+		case stubs.NeighbourhoodVonNeumann:
+			return abs(dx)+abs(dy) <= radius
+		case stubs.NeighbourhoodHexagonal:
+			return abs(dx-dy) <= radius
+		default:
+			return true
+		}
+	}
+	alive := func(x, y int) bool {
+		switch boundary {
+		case stubs.BoundaryDead:
+			if x < 0 || x >= width || y < 0 || y >= height {
+				return false
+			}
+		case stubs.BoundaryReflect:
+			if x < 0 {
+				x = -x - 1
+			} else if x >= width {
+				x = 2*width - 1 - x
+			}
+			if y < 0 {
+				y = -y - 1
+			} else if y >= height {
+				y = 2*height - 1 - y
+			}
+		case stubs.BoundaryKlein:
+			x = (x + width) % width
+			if y < 0 || y >= height {
+				x, y = width-1-x, (y+height)%height
+			}
+		case stubs.BoundaryCylinder:
+			if y < 0 || y >= height {
+				return false
+			}
+			x = (x + width) % width
+		default:
+			x, y = (x+width)%width, (y+height)%height
+		}
+		return world[y][x] == 255
+	}
+	states := rule.States
+	if states < 2 {
+		states = 2
+	}
+	state := func(level uint8) int {
+		for k := 0; k < states; k++ {
+			if rule.Level(k) == level {
+				return k
+			}
+		}
+		panic(fmt.Sprintf("level %v is not a state of %v", level, rule))
+	}
+	next := make([][]uint8, height)
+	for y := range next {
+		next[y] = make([]uint8, width)
+		for x := range next[y] {
+			n := 0
+			for dy := -radius; dy <= radius; dy++ {
+				for dx := -radius; dx <= radius; dx++ {
+					if (dx != 0 || dy != 0) && neighbour(dx, dy) && alive(x+dx, y+dy) {
+						n++
+					}
+				}
+			}
+			k := state(world[y][x])
+			switch {
+			case k == 0 && rule.Born(n), k == 1 && rule.Survives(n):
+				k = 1
+			case k == 0:
+			case k+1 < states:
+				k++
+			default:
+				k = 0
+			}
+			next[y][x] = rule.Level(k)
+		}
+	}
+	return next
+}
+
+// randomWorld fills a world with cells in random states of rule.
+func randomWorld(rng *rand.Rand, rule stubs.Rule, width, height int) [][]uint8 {
+	states := rule.States
+	if states < 2 {
+		states = 2
+	}
+	world := make([][]uint8, height)
+	for y := range world {
+		world[y] = make([]uint8, width)
+		for x := range world[y] {
+			if rng.Intn(3) == 0 {
+				world[y][x] = rule.Level(1 + rng.Intn(states-1))
+			}
+		}
+	}
+	return world
+}
+
+// testEngines returns every engine that can run req, starting from a copy of world,
+// and the world split between workers too if there are any.
+func testEngines(t *testing.T, req stubs.Request, world [][]uint8, workers []string) map[string]engine {
+	clone := func() [][]uint8 {
+		c := make([][]uint8, len(world))
+		for y := range world {
+			c[y] = append([]uint8(nil), world[y]...)
+		}
+		return c
+	}
+	engines := map[string]engine{
+		"cells":  newLocalEngine(req, clone()),
+		"sparse": newSparseEngine(req, clone()),
+	}
+	if checkBitboard(req) == nil {
+		engines["bitboard"] = newBitboardEngine(req, clone())
+	}
+	if checkHashLife(req) == nil {
+		engines["hashlife"] = newHashLife(req, clone())
+	}
+	if len(workers) > 0 {
+		broker := newTestOperations()
+		broker.workers = workers
+		remote, err := broker.newRemoteEngine(req, clone(), workers, func(warning string) { t.Error(warning) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		engines["remote"] = remote
+	}
+	return engines
+}
+
+// TestGenerations tests that the cells of Generations rules fade through their dying states one turn at a time.
+func TestGenerations(t *testing.T) {
+	workers := addresses(startWorkers(t, 3))
+	tests := []struct {
+		rule   string
+		levels []uint8
+	}{
+		{"B2/S/3", []uint8{255, 128, 0, 0}},
+		{"B2/S/4", []uint8{255, 170, 85, 0, 0}},
+		{"B3/S/6", []uint8{255, 204, 153, 102, 51, 0}},
+		{"B3/S0/4", []uint8{255, 255, 255}},
+	}
+	for _, test := range tests {
+		rule, err := stubs.ParseRule(test.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		world := make([][]uint8, 8)
+		for y := range world {
+			world[y] = make([]uint8, 8)
+		}
+		world[4][4] = 255
+		req := stubs.Request{ImageWidth: 8, ImageHeight: 8, Threads: 2, Rule: rule}
+		for name, e := range testEngines(t, req, world, workers) {
+			for turn, want := range test.levels[1:] {
+				if _, _, err := e.step(false); err != nil {
+					t.Fatal(err)
+				}
+				got, _ := e.world()
+				if got[4][4] != want {
+					t.Errorf("%v %v: lone cell at turn %v is %v, want %v", name, test.rule, turn+1, got[4][4], want)
+				}
+			}
+			e.close()
+		}
+	}
+
+	// Dying cells do not count as neighbours, so random worlds must match the reference.
+	rng := rand.New(rand.NewSource(1))
+	for _, name := range []string{"B2/S/3", "B34678/S234/24", "B3/S23/5", "B3/S23"} {
+		rule, _ := stubs.ParseRule(name)
+		world := randomWorld(rng, rule, 17, 11)
+		req := stubs.Request{ImageWidth: 17, ImageHeight: 11, Threads: 3, Rule: rule}
+		for engineName, e := range testEngines(t, req, world, workers) {
+			want := world
+			for turn := 1; turn <= 10; turn++ {
+				want = referenceStep(rule, stubs.BoundaryTorus, want)
+				if _, _, err := e.step(false); err != nil {
+					t.Fatal(err)
+				}
+				got, _ := e.world()
+				if fmt.Sprint(got) != fmt.Sprint(want) {
+					t.Errorf("%v %v: turn %v differs from the reference", engineName, name, turn)
+					break
+				}
+			}
+			e.close()
+		}
+	}
+}
+
+// TestBoundaries tests every engine on every boundary against the reference, on worlds whose width is
+// not a multiple of the bitboard engine's words so that cells cross its partly used last word.
+// Split between workers, the edges of the world fall in the strips at the top and bottom of the world.
+func TestBoundaries(t *testing.T) {
+	workers := addresses(startWorkers(t, 3))
+	rng := rand.New(rand.NewSource(2))
+	sizes := [][2]int{{9, 7}, {70, 5}, {16, 16}}
+	for _, boundary := range []stubs.Boundary{stubs.BoundaryTorus, stubs.BoundaryDead, stubs.BoundaryReflect, stubs.BoundaryKlein, stubs.BoundaryCylinder} {
+		for _, name := range []string{"B3/S23", "B36/S23", "B2/S/3"} {
+			rule, _ := stubs.ParseRule(name)
+			for _, size := range sizes {
+				world := randomWorld(rng, rule, size[0], size[1])
+				req := stubs.Request{ImageWidth: size[0], ImageHeight: size[1], Threads: 3, Rule: rule, Boundary: boundary}
+				for engineName, e := range testEngines(t, req, world, workers) {
+					want := world
+					for turn := 1; turn <= 10; turn++ {
+						want = referenceStep(rule, boundary, want)
+						if _, _, err := e.step(false); err != nil {
+							t.Fatal(err)
+						}
+						got, _ := e.world()
+						if fmt.Sprint(got) != fmt.Sprint(want) {
+							t.Errorf("%v %v %v %vx%v: turn %v differs from the reference", engineName, boundary, name, size[0], size[1], turn)
+							break
+						}
+					}
+					e.close()
+				}
+			}
+		}
+	}
+}
+
+// TestEvolveV1 tests that clients from before requests were versioned can still run a world, sending the messages
+// they were built with: the world unpacked, a NewWorld buffer, and no version.
+func TestEvolveV1(t *testing.T) {
+	type request struct {
+		World       [][]uint8
+		NewWorld    [][]uint8
+		ImageHeight int
+		ImageWidth  int
+		Turns       int
+	}
+	type response struct {
+		FinalWorld     [][]uint8
+		CompletedTurns int
+		AliveCells     int
+	}
+
+	server := rpc.NewServer()
+	if err := server.Register(&GolOperations{sessions: map[string]*session{}, maxSessions: 1, strips: map[int]*strip{}, shutdown: make(chan struct{})}); err != nil {
+		t.Fatal(err)
+	}
+	serverConn, clientConn := net.Pipe()
+	go server.ServeConn(serverConn)
+	client := rpc.NewClient(clientConn)
+	defer client.Close()
+
+	rng := rand.New(rand.NewSource(3))
+	world := randomWorld(rng, stubs.Life, 16, 16)
+	want := world
+	for turn := 0; turn < 10; turn++ {
+		want = referenceStep(stubs.Life, stubs.BoundaryTorus, want)
+	}
+	alive := 0
+	for _, row := range want {
+		for _, cell := range row {
+			if cell == 255 {
+				alive++
+			}
+		}
+	}
+	newWorld := randomWorld(rng, stubs.Life, 16, 16)
+	var res response
+	if err := client.Call(stubs.GolHandler, request{World: world, NewWorld: newWorld, ImageHeight: 16, ImageWidth: 16, Turns: 10}, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.CompletedTurns != 10 || res.AliveCells != alive || fmt.Sprint(res.FinalWorld) != fmt.Sprint(want) {
+		t.Errorf("reached turn %v with %v alive cells and the world %v, want turn 10 with %v and %v",
+			res.CompletedTurns, res.AliveCells, res.FinalWorld, alive, want)
+	}
+
+	err := client.Call(stubs.GolHandler, request{World: world[:15], ImageHeight: 16, ImageWidth: 16, Turns: 10}, &res)
+	if err == nil {
+		t.Error("a world with too few rows was run, want an error")
+	}
+}
+
+// TestShutdownKeepsCheckpoints tests that shutting the server down stops the sessions of other owners with their
+// checkpoints up to date and kept, so that they can be resumed once the server is back.
+func TestShutdownKeepsCheckpoints(t *testing.T) {
+	s := newTestOperations()
+	s.checkpoints, s.checkpointInterval = t.TempDir(), time.Hour
+	world := randomWorld(rand.New(rand.NewSource(5)), stubs.Life, 16, 16)
+	start := func(owner string, turns int, paused bool) string {
+		var res stubs.Response
+		err := s.Start(stubs.Request{Version: stubs.RequestVersion, Owner: owner, World: stubs.Pack(world), ImageWidth: 16, ImageHeight: 16,
+			Turns: turns, Threads: 1, Paused: paused}, &res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Session
+	}
+	mine := start("a", 5, false)
+	if err := s.Await(stubs.ControlRequest{Session: mine}, new(stubs.Response)); err != nil {
+		t.Fatal(err)
+	}
+	paused := start("b", 1<<30, true)
+	running := start("b", 1<<30, false)
+	ss, err := s.lookup(running)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for turn, _ := ss.progress(); turn == 0; turn, _ = ss.progress() {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := s.Shutdown(stubs.ControlRequest{Session: mine, Owner: "a"}, new(stubs.Response)); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadCheckpoint(s.checkpoints, paused)
+	if err != nil {
+		t.Fatalf("paused session: %v", err)
+	}
+	if c.Owner != "b" || c.CompletedTurns != 0 || fmt.Sprint(c.World.Unpack()) != fmt.Sprint(world) {
+		t.Errorf("paused session was saved by %v at turn %v, want by b at turn 0 with the world it started with", c.Owner, c.CompletedTurns)
+	}
+	c, err = loadCheckpoint(s.checkpoints, running)
+	if err != nil {
+		t.Fatalf("running session: %v", err)
+	}
+	if c.Owner != "b" || c.CompletedTurns == 0 {
+		t.Errorf("running session was saved by %v at turn %v, want by b after turn 0", c.Owner, c.CompletedTurns)
+	}
+
+	restarted := newTestOperations()
+	restarted.checkpoints, restarted.checkpointInterval = s.checkpoints, time.Hour
+	var res stubs.Response
+	if err := restarted.Restore(stubs.RestoreRequest{Session: running, ImageWidth: 16, ImageHeight: 16, Paused: true}, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.CompletedTurns != c.CompletedTurns {
+		t.Errorf("restored session at turn %v, want %v", res.CompletedTurns, c.CompletedTurns)
+	}
+	if err := restarted.Cancel(stubs.ControlRequest{Session: running, Owner: "b"}, new(stubs.Response)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestNeighbourhoods tests every engine that can run them on hexagonal, von Neumann and Larger than Life rules
+// against the reference, with every boundary.
+func TestNeighbourhoods(t *testing.T) {
+	workers := addresses(startWorkers(t, 3))
+	rng := rand.New(rand.NewSource(6))
+	rules := []string{
+		"B2/S34H", "B2/S2/4H", "B2/S013V", "B1/S1V",
+		"R2,C0,M1,S2..6,B4..5,NM", "R3,C0,M0,S4..12,B6..9,NN", "R2,C0,M0,S3..7,B4..6,NH",
+		"R2,C3,M0,S3..8,B4..6,NM", "R5,C0,M1,S30..60,B28..45,NM",
+	}
+	for _, boundary := range []stubs.Boundary{stubs.BoundaryTorus, stubs.BoundaryDead, stubs.BoundaryReflect, stubs.BoundaryKlein, stubs.BoundaryCylinder} {
+		for _, name := range rules {
+			rule, err := stubs.ParseRule(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			world := randomWorld(rng, rule, 23, 20)
+			req := stubs.Request{ImageWidth: 23, ImageHeight: 20, Threads: 3, Rule: rule, Boundary: boundary}
+			for engineName, e := range testEngines(t, req, world, workers) {
+				want := world
+				for turn := 1; turn <= 8; turn++ {
+					want = referenceStep(rule, boundary, want)
+					if _, _, err := e.step(false); err != nil {
+						t.Fatal(err)
+					}
+					got, _ := e.world()
+					if fmt.Sprint(got) != fmt.Sprint(want) {
+						t.Errorf("%v %v %v: turn %v differs from the reference", engineName, boundary, name, turn)
+						break
+					}
+				}
+				e.close()
+			}
+		}
+	}
+}
@@ -0,0 +1,588 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+var errNoSession = errors.New("no such session")
+
+var errCancelled = errors.New("session was cancelled")
+
+var errEvicted = errors.New("session was stopped to make room for another of its owner's, resume it from its checkpoint")
+
+var errShutDown = errors.New("server was shut down, resume the session from its checkpoint")
+
+// streamBuffer is how many turns a StreamTurns session may run ahead of its controller.
+const streamBuffer = 64
+
+// streamTimeout is how long a StreamTurns session waits for a controller that has stopped reading
+// before it gives up on streaming and carries on without it.
+const streamTimeout = 10 * time.Second
+
+// engine advances a world one turn at a time, either locally or across the worker pool.
+type engine interface {
+	// step computes the next turn and returns the number of alive cells in it,
+	// along with the cells that changed state if track is set.
+	step(track bool) (int, flips, error)
+	// world returns a copy of the current state of the world.
+	world() ([][]uint8, error)
+	close()
+}
+
+// tiler is an engine that only steps the parts of the world that may have changed.
+type tiler interface {
+	// skippedTiles returns the fraction of the world left alone since the last call.
+	skippedTiles() float64
+}
+
+// hasher is an engine that can hash its world without copying it, for spotting when the world repeats itself.
+type hasher interface {
+	// hash returns a hash of the current world.
+	hash() (uint64, error)
+}
+
+// leaper is an engine that can advance many turns at once, for when nobody is watching each turn go by.
+type leaper interface {
+	// leap advances at least one and at most turns turns, returning how many it advanced
+	// and the number of alive cells left.
+	leap(turns int) (int, int, error)
+}
+
+// session is a single run of Evolve. Controls sent by other RPC calls are applied by the
+// evolving goroutine between turns, so they always see a consistent world.
+type session struct {
+	id       string
+	owner    string
+	started  time.Time
+	width    int
+	height   int
+	threads  int
+	rule     stubs.Rule
+	boundary stubs.Boundary
+	turns    int
+	// engineKind is the kind of engine the session was started with, kept for its checkpoints.
+	engineKind stubs.Engine
+	engine     engine
+	controls   chan func()
+	done       chan struct{}
+
+	// history remembers the recent worlds of a session watching for cycles until it has found one.
+	// It is only used by the evolving goroutine.
+	cycles  stubs.Cycles
+	history *history
+
+	// final and err hold the outcome of the run once done is closed.
+	final [][]uint8
+	err   error
+
+	// paused, quit and cancelled are only changed by the evolving goroutine.
+	// paused is changed under mu so that List can read it.
+	paused    bool
+	quit      bool
+	cancelled bool
+	// evicted is set when the run is stopped to make room for another of its owner's sessions, or because
+	// the server is shutting down, to the error reported to its controller. Its checkpoint is brought up to date
+	// and kept so that it can be resumed.
+	evicted error
+
+	// checkpoints is the directory the run is saved to every checkpointInterval, or empty to not save it.
+	checkpoints        string
+	checkpointInterval time.Duration
+	checkpointAt       time.Time
+
+	mu       sync.Mutex
+	turn     int
+	alive    int
+	stream   *stream
+	warnings []string
+	// detached is set while no controller is attached to the session, since detachedSince.
+	detached      bool
+	detachedSince time.Time
+	// collected is set once Await has returned the outcome of the run.
+	collected bool
+	// cycleStart and cyclePeriod describe the cycle the world has settled into, once one has been found.
+	cycleStart  int
+	cyclePeriod int
+}
+
+// stream carries the cells flipped by each turn to the attached controller.
+// It is only replaced by the evolving goroutine.
+type stream struct {
+	mode int
+	// diffs holds the turns not yet collected in StreamTurns mode and is closed when the stream ends.
+	diffs chan flippedTurn
+	// merged holds the cells flipped since the last collection in StreamFrames mode, guarded by the session's mu.
+	// For Generations rules, shaded holds the latest grey level of every cell changed since then instead.
+	merged     map[util.Cell]bool
+	shaded     map[util.Cell]uint8
+	mergedTurn int
+	ended      bool
+	// drained is set once the controller has been told the stream is done, guarded by the session's mu.
+	drained bool
+}
+
+func (st *stream) end() {
+	if st.diffs != nil && !st.ended {
+		close(st.diffs)
+	}
+	st.ended = true
+}
+
+// flippedTurn lists the cells flipped to reach a turn, before they are packed for the wire.
+type flippedTurn struct {
+	turn int
+	flips
+}
+
+// newSession sets up a session for req. Its engine must be set before it is run.
+func newSession(id string, req stubs.Request, alive int) *session {
+	ss := &session{
+		id:         id,
+		owner:      req.Owner,
+		started:    time.Now(),
+		width:      req.ImageWidth,
+		height:     req.ImageHeight,
+		threads:    req.Threads,
+		rule:       req.Rule,
+		boundary:   req.Boundary,
+		engineKind: req.Engine,
+		cycles:     req.Cycles,
+		turns:      req.Turns,
+		controls:   make(chan func()),
+		done:       make(chan struct{}),
+		paused:     req.Paused,
+		alive:      alive,
+	}
+	if req.Cycles != stubs.CyclesOff {
+		ss.history = newHistory()
+	}
+	ss.setStream(req.Stream)
+	return ss
+}
+
+// run steps the engine until every turn has completed or the session is told to quit,
+// then records the final world and releases the engine. The checkpoint is only kept if the run failed or was evicted.
+func (ss *session) run() {
+	ss.err = ss.evolve()
+	if ss.err == nil && ss.evicted != nil {
+		turn, _ := ss.progress()
+		ss.checkpointAt = time.Time{}
+		ss.err = ss.checkpoint(turn)
+	}
+	if ss.err == nil && !ss.cancelled {
+		ss.final, ss.err = ss.engine.world()
+	}
+	ss.engine.close()
+	if ss.err == nil && ss.checkpoints != "" && ss.evicted == nil {
+		removeCheckpoint(ss.checkpoints, ss.id)
+	}
+	switch {
+	case ss.cancelled:
+		ss.err = errCancelled
+	case ss.evicted != nil && ss.err == nil:
+		ss.err = ss.evicted
+	}
+	close(ss.done)
+}
+
+func (ss *session) evolve() error {
+	defer ss.endStream()
+	turn, _ := ss.progress()
+	ss.checkpointAt = time.Now()
+	_, err := ss.watch(turn)
+	if err != nil {
+		return err
+	}
+	for {
+		ss.applyControls()
+		if turn >= ss.turns || ss.quit {
+			return nil
+		}
+		advanced, alive, flipped, err := ss.advance(ss.turns - turn)
+		if err != nil {
+			return err
+		}
+		turn, err = ss.watch(turn + advanced)
+		if err != nil {
+			return err
+		}
+		ss.report(turn, alive)
+		ss.publish(turn, flipped)
+		err = ss.checkpoint(turn)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// advance moves the engine on by one turn, or by up to turns at once if the engine can leap,
+// nobody is being streamed each turn and no turn needs to be checked for cycles.
+func (ss *session) advance(turns int) (int, int, flips, error) {
+	if l, ok := ss.engine.(leaper); ok && ss.stream == nil && ss.history == nil {
+		advanced, alive, err := l.leap(turns)
+		return advanced, alive, flips{}, err
+	}
+	alive, flipped, err := ss.engine.step(ss.stream != nil)
+	return 1, alive, flipped, err
+}
+
+// watch looks for the world reached at turn among the recent worlds of a session watching for cycles.
+// A world sharing its hash with an earlier one is kept as a candidate, and once the world a period later turns out
+// to be the same cell by cell, the cycle is recorded and, for CyclesStop, as many whole cycles as fit
+// before the last turn are skipped, since they would end in the same world. It returns the turn reached.
+func (ss *session) watch(turn int) (int, error) {
+	h := ss.history
+	if h == nil {
+		return turn, nil
+	}
+	var world [][]uint8
+	current := func() ([][]uint8, error) {
+		if world != nil {
+			return world, nil
+		}
+		var err error
+		world, err = ss.engine.world()
+		return world, err
+	}
+	var hash uint64
+	if e, ok := ss.engine.(hasher); ok {
+		var err error
+		hash, err = e.hash()
+		if err != nil {
+			return turn, err
+		}
+	} else {
+		w, err := current()
+		if err != nil {
+			return turn, err
+		}
+		hash = worldHash(w)
+	}
+
+	c := h.candidate
+	if c == nil {
+		earlier, ok := h.see(turn, hash)
+		if !ok {
+			return turn, nil
+		}
+		w, err := current()
+		if err != nil {
+			return turn, err
+		}
+		h.candidate = &candidate{world: w, hash: hash, turn: turn, earlier: earlier}
+		return turn, nil
+	}
+	period := c.turn - c.earlier
+	if turn < c.turn+period {
+		h.see(turn, hash)
+		return turn, nil
+	}
+	h.candidate = nil
+	w, err := current()
+	if err != nil {
+		return turn, err
+	}
+	if !sameWorld(w, c.world) {
+		log.Println("Session", ss.id, "only shares a hash between turns", c.earlier, "and", c.turn)
+		h.replace(c.hash, c.turn)
+		return turn, nil
+	}
+
+	ss.history = nil
+	log.Println("Session", ss.id, "repeats itself every", period, "turns from turn", c.earlier)
+	ss.mu.Lock()
+	ss.cycleStart, ss.cyclePeriod = c.earlier, period
+	ss.mu.Unlock()
+	if ss.cycles == stubs.CyclesStop && turn < ss.turns {
+		turn += (ss.turns - turn) / period * period
+	}
+	return turn, nil
+}
+
+// cycle returns the first turn and period of the cycle the world has settled into, or zeros if none has been found.
+func (ss *session) cycle() (start, period int) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.cycleStart, ss.cyclePeriod
+}
+
+// setStream replaces the session's stream, ending the previous one. It must only be called
+// by the evolving goroutine, either directly or from a control.
+func (ss *session) setStream(mode int) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.stream != nil {
+		ss.stream.end()
+	}
+	switch mode {
+	case stubs.StreamTurns:
+		ss.stream = &stream{mode: mode, diffs: make(chan flippedTurn, streamBuffer)}
+	case stubs.StreamFrames:
+		ss.stream = &stream{mode: mode, merged: make(map[util.Cell]bool), shaded: make(map[util.Cell]uint8), mergedTurn: ss.turn}
+	default:
+		ss.stream = nil
+	}
+}
+
+// endStream marks the end of the run, letting the controller collect whatever is left in the stream.
+func (ss *session) endStream() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.stream != nil {
+		ss.stream.end()
+	}
+}
+
+// publish hands the cells flipped by a turn to the stream, if there is one.
+func (ss *session) publish(turn int, flipped flips) {
+	st := ss.stream
+	if st == nil {
+		return
+	}
+	switch st.mode {
+	case stubs.StreamTurns:
+		select {
+		case st.diffs <- flippedTurn{turn: turn, flips: flipped}:
+		case <-time.After(streamTimeout):
+			log.Println("Session", ss.id, "stopped streaming to an unresponsive controller")
+			ss.setStream(stubs.StreamOff)
+		}
+	case stubs.StreamFrames:
+		ss.mu.Lock()
+		for i, cell := range flipped.cells {
+			if flipped.levels != nil {
+				st.shaded[cell] = flipped.levels[i]
+			} else if st.merged[cell] {
+				delete(st.merged, cell)
+			} else {
+				st.merged[cell] = true
+			}
+		}
+		st.mergedTurn = turn
+		ss.mu.Unlock()
+	}
+}
+
+// flipped collects the diffs streamed since the last call. In StreamTurns mode it waits for at
+// least one turn to be ready.
+func (ss *session) flipped(res *stubs.FlippedResponse) {
+	ss.mu.Lock()
+	st := ss.stream
+	ss.mu.Unlock()
+	if st == nil {
+		res.Done = true
+		return
+	}
+	defer func() {
+		if res.Done {
+			ss.mu.Lock()
+			st.drained = true
+			ss.mu.Unlock()
+		}
+	}()
+
+	switch st.mode {
+	case stubs.StreamTurns:
+		diff, ok := <-st.diffs
+		if !ok {
+			res.Done = true
+			return
+		}
+		res.Turns = append(res.Turns, ss.pack(diff))
+		for len(res.Turns) < streamBuffer {
+			select {
+			case diff, ok := <-st.diffs:
+				if !ok {
+					return
+				}
+				res.Turns = append(res.Turns, ss.pack(diff))
+			default:
+				return
+			}
+		}
+	case stubs.StreamFrames:
+		finished := false
+		select {
+		case <-ss.done:
+			finished = true
+		default:
+		}
+		ss.mu.Lock()
+		diff := flippedTurn{turn: st.mergedTurn}
+		for cell := range st.merged {
+			diff.cells = append(diff.cells, cell)
+		}
+		for cell, level := range st.shaded {
+			diff.cells = append(diff.cells, cell)
+			diff.levels = append(diff.levels, level)
+		}
+		st.merged = make(map[util.Cell]bool)
+		st.shaded = make(map[util.Cell]uint8)
+		ss.mu.Unlock()
+		res.Turns = append(res.Turns, ss.pack(diff))
+		res.Done = finished && len(diff.cells) == 0
+	}
+}
+
+func (ss *session) pack(diff flippedTurn) stubs.TurnDiff {
+	diff.sort()
+	return stubs.TurnDiff{CompletedTurns: diff.turn, Flipped: stubs.PackCells(ss.width, ss.height, diff.cells), Levels: diff.levels}
+}
+
+// applyControls runs any pending controls, blocking for more while the session is paused.
+func (ss *session) applyControls() {
+	for {
+		if ss.paused && !ss.quit {
+			f := <-ss.controls
+			f()
+			continue
+		}
+		select {
+		case f := <-ss.controls:
+			f()
+		default:
+			return
+		}
+	}
+}
+
+// control hands f to the evolving goroutine and waits for it to be applied.
+func (ss *session) control(f func()) error {
+	applied := make(chan struct{})
+	select {
+	case ss.controls <- func() { f(); close(applied) }:
+		<-applied
+		return nil
+	case <-ss.done:
+		return errNoSession
+	}
+}
+
+// report records the progress of the run so that CountAlive can read it while Evolve is busy.
+func (ss *session) report(turn, alive int) {
+	ss.mu.Lock()
+	ss.turn = turn
+	ss.alive = alive
+	ss.mu.Unlock()
+}
+
+// warn records a problem the run has recovered from, to be passed on to the controller.
+func (ss *session) warn(message string) {
+	log.Println("Session", ss.id+":", message)
+	ss.mu.Lock()
+	ss.warnings = append(ss.warnings, message)
+	ss.mu.Unlock()
+}
+
+// takeWarnings returns the warnings recorded since the last call.
+func (ss *session) takeWarnings() []string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	warnings := ss.warnings
+	ss.warnings = nil
+	return warnings
+}
+
+func (ss *session) progress() (turn, alive int) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.turn, ss.alive
+}
+
+// await blocks until the run has finished and fills res with its outcome.
+func (ss *session) await(res *stubs.Response) error {
+	<-ss.done
+	ss.mu.Lock()
+	ss.collected = true
+	ss.mu.Unlock()
+	if ss.err != nil {
+		return ss.err
+	}
+	res.Session = ss.id
+	res.CompletedTurns, res.AliveCells = ss.progress()
+	res.Warnings = ss.takeWarnings()
+	res.CycleStart, res.CyclePeriod = ss.cycle()
+	res.FinalWorld = stubs.Pack(ss.final)
+	return nil
+}
+
+// evict tells the run to quit, saving a checkpoint to resume it from, and waits for it to wind down.
+// The run then fails with reason.
+func (ss *session) evict(reason error) {
+	_ = ss.control(func() { ss.quit, ss.evicted = true, reason })
+	<-ss.done
+}
+
+// cancel tells the run to quit without keeping its world and waits for it to wind down.
+func (ss *session) cancel() {
+	_ = ss.control(func() { ss.quit, ss.cancelled = true, true })
+	<-ss.done
+}
+
+// spent reports whether the outcome of the run has been collected and its stream, if any, drained,
+// so that the server can forget the session.
+func (ss *session) spent() bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.collected && (ss.stream == nil || ss.stream.drained)
+}
+
+func (ss *session) finished() bool {
+	select {
+	case <-ss.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (ss *session) setPaused(paused bool) {
+	ss.mu.Lock()
+	ss.paused = paused
+	ss.mu.Unlock()
+}
+
+func (ss *session) setDetached(detached bool) {
+	ss.mu.Lock()
+	ss.detached = detached
+	ss.detachedSince = time.Now()
+	ss.mu.Unlock()
+}
+
+func (ss *session) isDetached() bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.detached
+}
+
+func (ss *session) detachedAt() time.Time {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.detachedSince
+}
+
+// info describes the session for List.
+func (ss *session) info() stubs.SessionInfo {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return stubs.SessionInfo{
+		Session:        ss.id,
+		Owner:          ss.owner,
+		Started:        ss.started,
+		ImageWidth:     ss.width,
+		ImageHeight:    ss.height,
+		Rule:           ss.rule,
+		Boundary:       ss.boundary,
+		CompletedTurns: ss.turn,
+		Turns:          ss.turns,
+		AliveCells:     ss.alive,
+		Paused:         ss.paused,
+		Detached:       ss.detached,
+		Finished:       ss.finished(),
+	}
+}
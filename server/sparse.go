@@ -0,0 +1,227 @@
+package main
+
+import (
+	"sync"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// tileSize is the width and height of the tiles a sparseEngine divides the world into,
+// unless the rule reaches further than that.
+const tileSize = 16
+
+// sparseEngine steps the world on this machine like localEngine, but only the tiles that changed last turn
+// and their neighbours. Nothing else can change, so the rest of the world is copied across as it is.
+type sparseEngine struct {
+	rule     *lifeRule
+	topology *topology
+	height   int
+	width    int
+	threads  int
+	current  [][]uint8
+	next     [][]uint8
+
+	tile   int
+	tilesX int
+	tilesY int
+	// active marks the tiles to step next turn, and alive counts the alive cells in each tile.
+	active []bool
+	alive  []int
+
+	// stepped and skipped count the tiles stepped and left alone since the last call to skippedTiles.
+	mu      sync.Mutex
+	stepped int
+	skipped int
+}
+
+func newSparseEngine(req stubs.Request, world [][]uint8) *sparseEngine {
+	next := make([][]uint8, req.ImageHeight)
+	for i := range next {
+		next[i] = make([]uint8, req.ImageWidth)
+	}
+	e := &sparseEngine{
+		rule:     newLifeRule(req.Rule),
+		topology: newTopology(req.Boundary, req.ImageWidth),
+		height:   req.ImageHeight,
+		width:    req.ImageWidth,
+		threads:  req.Threads,
+		current:  world,
+		next:     next,
+		tile:     tileSize,
+	}
+	// Every cell a changed cell can reach must lie in the tiles holding the cells a whole radius from it.
+	if e.rule.radius > e.tile {
+		e.tile = e.rule.radius
+	}
+	e.tilesX = (e.width + e.tile - 1) / e.tile
+	e.tilesY = (e.height + e.tile - 1) / e.tile
+	e.active = make([]bool, e.tilesX*e.tilesY)
+	for i := range e.active {
+		e.active[i] = true
+	}
+	e.alive = make([]int, len(e.active))
+	return e
+}
+
+// locate returns the cell standing in for (x, y), which may be up to a world's width or height beyond
+// its edges, and false if the cell there is always dead.
+func (e *sparseEngine) locate(x, y int) (util.Cell, bool) {
+	if y < 0 || y >= e.height {
+		switch e.topology.boundary {
+		case stubs.BoundaryDead, stubs.BoundaryCylinder:
+			return util.Cell{}, false
+		case stubs.BoundaryReflect:
+			if y < 0 {
+				y = -y - 1
+			} else {
+				y = 2*e.height - 1 - y
+			}
+		case stubs.BoundaryKlein:
+			y = (y + e.height) % e.height
+			x = e.width - 1 - x
+		default:
+			y = (y + e.height) % e.height
+		}
+	}
+	x = e.topology.column(x)
+	return util.Cell{X: x, Y: y}, x >= 0
+}
+
+func (e *sparseEngine) tileOf(cell util.Cell) int {
+	return cell.Y/e.tile*e.tilesX + cell.X/e.tile
+}
+
+func (e *sparseEngine) step(track bool) (int, flips, error) {
+	r := e.rule.radius
+	top := e.topology.beyond(e.current[:r], e.current[e.height-r:])
+	bottom := e.topology.beyond(e.current[e.height-r:], e.current[:r])
+	rows := make([][]uint8, 0, e.height+2*r)
+	rows = append(append(append(rows, top...), e.current...), bottom...)
+
+	active := make([]bool, len(e.active))
+	var flipped flips
+	var mu sync.Mutex
+	splitRows(e.tilesY, e.threads, func(startT, endT int) int {
+		var band flips
+		var totals [][]int32
+		if e.rule.wide {
+			totals = make([][]int32, 2*r+1)
+			for i := range totals {
+				totals[i] = make([]int32, e.width+2*r+1)
+			}
+		}
+		// changed lists the tiles that changed, and reaching the changed cells close enough to the edge
+		// of their tile to affect the tiles around it.
+		var changed []int
+		var reaching []util.Cell
+		stepped, skipped := 0, 0
+		for ty := startT; ty < endT; ty++ {
+			startY, endY := ty*e.tile, (ty+1)*e.tile
+			if endY > e.height {
+				endY = e.height
+			}
+			for tx := 0; tx < e.tilesX; tx++ {
+				startX, endX := tx*e.tile, (tx+1)*e.tile
+				if endX > e.width {
+					endX = e.width
+				}
+				i := ty*e.tilesX + tx
+				if !e.active[i] {
+					for y := startY; y < endY; y++ {
+						copy(e.next[y][startX:endX], e.current[y][startX:endX])
+					}
+					skipped++
+					continue
+				}
+				stepped++
+
+				alive := 0
+				tileChanged := false
+				for y := startY; y < endY; y++ {
+					// The row being stepped is rows[y+r], with r rows either side of it.
+					if e.rule.wide {
+						alive += calculateNextRowWide(e.rule, e.topology, startX, endX, rows[y:y+2*r+1], e.next[y], totals)
+					} else {
+						alive += calculateNextRow(e.rule, e.topology, startX, endX, rows[y], e.current[y], rows[y+2], e.next[y])
+					}
+					for x := startX; x < endX; x++ {
+						if e.current[y][x] == e.next[y][x] {
+							continue
+						}
+						tileChanged = true
+						if track {
+							band.cells = append(band.cells, util.Cell{X: x, Y: y})
+							if e.rule.generations {
+								band.levels = append(band.levels, e.next[y][x])
+							}
+						}
+						if x-startX < r || endX-1-x < r || y-startY < r || endY-1-y < r {
+							reaching = append(reaching, util.Cell{X: x, Y: y})
+						}
+					}
+				}
+				e.alive[i] = alive
+				if tileChanged {
+					changed = append(changed, i)
+				}
+			}
+		}
+
+		mu.Lock()
+		flipped.append(band)
+		for _, i := range changed {
+			active[i] = true
+		}
+		for _, cell := range reaching {
+			for dy := -r; dy <= r; dy += r {
+				for dx := -r; dx <= r; dx += r {
+					if neighbour, ok := e.locate(cell.X+dx, cell.Y+dy); ok {
+						active[e.tileOf(neighbour)] = true
+					}
+				}
+			}
+		}
+		mu.Unlock()
+
+		e.mu.Lock()
+		e.stepped += stepped
+		e.skipped += skipped
+		e.mu.Unlock()
+		return 0
+	})
+
+	e.current, e.next = e.next, e.current
+	e.active = active
+	alive := 0
+	for _, n := range e.alive {
+		alive += n
+	}
+	return alive, flipped, nil
+}
+
+// skippedTiles returns the fraction of tiles left alone since the last call.
+func (e *sparseEngine) skippedTiles() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	fraction := 0.0
+	if total := e.stepped + e.skipped; total > 0 {
+		fraction = float64(e.skipped) / float64(total)
+	}
+	e.stepped, e.skipped = 0, 0
+	return fraction
+}
+
+func (e *sparseEngine) hash() (uint64, error) {
+	return worldHash(e.current), nil
+}
+
+func (e *sparseEngine) world() ([][]uint8, error) {
+	world := make([][]uint8, e.height)
+	for y := range world {
+		world[y] = append([]uint8(nil), e.current[y]...)
+	}
+	return world, nil
+}
+
+func (e *sparseEngine) close() {
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/rpc"
+	"sync"
+	"time"
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// registerInterval is how often a worker registers with the broker again, so that it rejoins the pool
+// if the broker has given up on it, such as after a heartbeat was lost to a brief network stall.
+const registerInterval = 10 * time.Second
+
+// strip is a horizontal band of the world held by a worker between turns.
+type strip struct {
+	rule     *lifeRule
+	topology *topology
+	width    int
+	threads  int
+	world    [][]uint8
+	newWorld [][]uint8
+}
+
+// calculateNextStrip computes the next state of a strip under rule, using the halo rows top and bottom,
+// as many as the rule's radius, in place of the rows held by the neighbouring workers. If flipped is not nil,
+// the cells that changed state are appended to it, numbering rows from the top of the strip.
+func calculateNextStrip(rule *lifeRule, topo *topology, imageWidth, threads int, top, bottom [][]uint8, world, resultWorld [][]uint8, flipped *flips) int {
+	r := rule.radius
+	rows := make([][]uint8, 0, len(top)+len(world)+len(bottom))
+	rows = append(append(append(rows, top...), world...), bottom...)
+	var mu sync.Mutex
+	return splitRows(len(world), threads, func(startY, endY int) int {
+		alive := 0
+		var band flips
+		var totals [][]int32
+		if rule.wide {
+			totals = make([][]int32, 2*r+1)
+			for i := range totals {
+				totals[i] = make([]int32, imageWidth+2*r+1)
+			}
+		}
+		for y := startY; y < endY; y++ {
+			// The row being stepped is rows[y+r], with r rows either side of it.
+			if rule.wide {
+				alive += calculateNextRowWide(rule, topo, 0, imageWidth, rows[y:y+2*r+1], resultWorld[y], totals)
+			} else {
+				alive += calculateNextRow(rule, topo, 0, imageWidth, rows[y], world[y], rows[y+2], resultWorld[y])
+			}
+			if flipped != nil {
+				band.appendFlipped(y, world[y], resultWorld[y], rule.generations)
+			}
+		}
+		if len(band.cells) > 0 {
+			mu.Lock()
+			flipped.append(band)
+			mu.Unlock()
+		}
+		return alive
+	})
+}
+
+// register announces this worker to the broker so that it is included in future runs.
+func register(broker, address string) error {
+	client, err := rpc.Dial("tcp", broker)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Call(stubs.RegisterHandler, stubs.RegisterRequest{Address: address}, new(stubs.RegisterResponse))
+}
+
+// keepRegistered registers with the broker every interval until stop is closed. Registering is harmless
+// while the broker still knows the worker, and failures are only logged until registering works again.
+func keepRegistered(broker, address string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		err := register(broker, address)
+		if err != nil && !failing {
+			log.Println("Registering again with", broker, "failed:", err)
+		}
+		failing = err != nil
+	}
+}
+
+func (s *GolOperations) InitStrip(req stubs.StripRequest, res *stubs.StripResponse) (err error) {
+	world := req.Strip.Unpack()
+	newWorld := make([][]uint8, len(world))
+	for i := range newWorld {
+		newWorld[i] = make([]uint8, req.ImageWidth)
+	}
+	s.mu.Lock()
+	s.strips[req.ID] = &strip{
+		rule:     newLifeRule(req.Rule),
+		topology: newTopology(req.Boundary, req.ImageWidth),
+		width:    req.ImageWidth,
+		threads:  req.Threads,
+		world:    world,
+		newWorld: newWorld,
+	}
+	s.mu.Unlock()
+	return
+}
+
+func (s *GolOperations) StepStrip(req stubs.HaloRequest, res *stubs.HaloResponse) (err error) {
+	s.mu.Lock()
+	st, ok := s.strips[req.ID]
+	s.mu.Unlock()
+	if !ok {
+		return errors.New("unknown strip")
+	}
+
+	var flipped flips
+	var tracked *flips
+	if req.Track {
+		tracked = &flipped
+	}
+	res.Alive = calculateNextStrip(st.rule, st.topology, st.width, st.threads, req.Top.Unpack(), req.Bottom.Unpack(), st.world, st.newWorld, tracked)
+	st.world, st.newWorld = st.newWorld, st.world
+
+	r := st.rule.radius
+	res.Top = stubs.Pack(st.world[:r])
+	res.Bottom = stubs.Pack(st.world[len(st.world)-r:])
+	if req.Track {
+		flipped.sort()
+		res.Flipped = stubs.PackCells(st.width, len(st.world), flipped.cells)
+		res.Levels = flipped.levels
+	}
+	if req.Hash {
+		res.Hashes = make([]uint64, len(st.world))
+		for y, row := range st.world {
+			res.Hashes[y] = rowHash(row)
+		}
+	}
+	return
+}
+
+func (s *GolOperations) CollectStrip(req stubs.StripRequest, res *stubs.StripResponse) (err error) {
+	s.mu.Lock()
+	st, ok := s.strips[req.ID]
+	s.mu.Unlock()
+	if !ok {
+		return errors.New("unknown strip")
+	}
+	res.Strip = stubs.Pack(st.world)
+	return
+}
+
+// Heartbeat lets the broker check that this worker is still responding.
+func (s *GolOperations) Heartbeat(req stubs.ControlRequest, res *stubs.Response) (err error) {
+	return
+}
+
+func (s *GolOperations) FreeStrip(req stubs.StripRequest, res *stubs.StripResponse) (err error) {
+	s.mu.Lock()
+	delete(s.strips, req.ID)
+	s.mu.Unlock()
+	return
+}
@@ -0,0 +1,48 @@
+package stubs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Boundary decides what lies beyond the edges of the world. The zero Boundary is a torus.
+type Boundary int
+
+const (
+	// BoundaryTorus wraps the left edge round to the right and the top edge round to the bottom.
+	BoundaryTorus Boundary = iota
+	// BoundaryDead surrounds the world with cells that are always dead.
+	BoundaryDead
+	// BoundaryReflect mirrors the world in its edges, so that each edge cell neighbours itself.
+	BoundaryReflect
+	// BoundaryKlein wraps like a torus, except that the top and bottom edges are joined back to front,
+	// so the row above the top row is the bottom row reversed.
+	BoundaryKlein
+	// BoundaryCylinder wraps the left edge round to the right, with dead cells above and below the world.
+	BoundaryCylinder
+)
+
+var boundaryNames = []string{
+	BoundaryTorus:    "torus",
+	BoundaryDead:     "dead",
+	BoundaryReflect:  "reflect",
+	BoundaryKlein:    "klein",
+	BoundaryCylinder: "cylinder",
+}
+
+// ParseBoundary reads a boundary by name: torus, dead, reflect, klein or cylinder.
+func ParseBoundary(s string) (Boundary, error) {
+	for b, name := range boundaryNames {
+		if strings.EqualFold(s, name) {
+			return Boundary(b), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown boundary %q, expected one of %v", s, strings.Join(boundaryNames, ", "))
+}
+
+func (b Boundary) String() string {
+	if b < 0 || int(b) >= len(boundaryNames) {
+		return fmt.Sprintf("Boundary(%d)", int(b))
+	}
+	return boundaryNames[b]
+}
@@ -0,0 +1,43 @@
+package stubs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Cycles decides whether a session watches for the world settling into a still life or an oscillator.
+// The zero Cycles does not watch.
+type Cycles int
+
+const (
+	// CyclesOff never looks for the world repeating itself.
+	CyclesOff Cycles = iota
+	// CyclesDetect reports the turn the world starts repeating itself and how often, but still runs every turn.
+	CyclesDetect
+	// CyclesStop also skips the turns left once the world repeats itself, ending the run at once
+	// with the world the last turn would have reached.
+	CyclesStop
+)
+
+var cyclesNames = []string{
+	CyclesOff:    "off",
+	CyclesDetect: "detect",
+	CyclesStop:   "stop",
+}
+
+// ParseCycles reads a cycle detection mode by name: off, detect or stop.
+func ParseCycles(s string) (Cycles, error) {
+	for c, name := range cyclesNames {
+		if strings.EqualFold(s, name) {
+			return Cycles(c), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown cycle detection %q, expected one of %v", s, strings.Join(cyclesNames, ", "))
+}
+
+func (c Cycles) String() string {
+	if c < 0 || int(c) >= len(cyclesNames) {
+		return fmt.Sprintf("Cycles(%d)", int(c))
+	}
+	return cyclesNames[c]
+}
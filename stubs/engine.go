@@ -0,0 +1,48 @@
+package stubs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Engine picks how the server evolves a world. The zero Engine steps every cell every turn.
+type Engine int
+
+const (
+	// EngineCells steps every cell every turn, splitting the world between the workers if there are any.
+	EngineCells Engine = iota
+	// EngineHashLife evolves the world on the broker as a memoised quadtree, which can leap ahead
+	// by billions of turns when the pattern is sparse or repeats itself. It only runs two-state
+	// rules in B/S notation on the Moore neighbourhood, on a torus whose sides are powers of two.
+	EngineHashLife
+	// EngineSparse steps the world on the broker in tiles, skipping the tiles that cannot have changed
+	// because nothing near them changed the turn before. It suits worlds that have mostly settled.
+	EngineSparse
+	// EngineBitboard steps every cell every turn on the broker, 64 cells at a time packed into each word,
+	// counting neighbours with bitwise adders. It only runs two-state rules in B/S notation on the Moore neighbourhood.
+	EngineBitboard
+)
+
+var engineNames = []string{
+	EngineCells:    "cells",
+	EngineHashLife: "hashlife",
+	EngineSparse:   "sparse",
+	EngineBitboard: "bitboard",
+}
+
+// ParseEngine reads an engine by name: cells, hashlife, sparse or bitboard.
+func ParseEngine(s string) (Engine, error) {
+	for e, name := range engineNames {
+		if strings.EqualFold(s, name) {
+			return Engine(e), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown engine %q, expected one of %v", s, strings.Join(engineNames, ", "))
+}
+
+func (e Engine) String() string {
+	if e < 0 || int(e) >= len(engineNames) {
+		return fmt.Sprintf("Engine(%d)", int(e))
+	}
+	return engineNames[e]
+}
@@ -0,0 +1,227 @@
+package stubs
+
+import (
+	"encoding/binary"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Encoding says how the cells of a Packed world are laid out in Data.
+type Encoding uint8
+
+const (
+	// EncodingBits stores one bit per cell, row by row, most significant bit first.
+	EncodingBits Encoding = iota
+	// EncodingRLE stores the bit-packed bytes as (run length, byte) pairs, which suits large empty or full areas.
+	EncodingRLE
+	// EncodingDelta stores the gaps between the indices of consecutive alive cells, which suits sparse worlds and diffs.
+	EncodingDelta
+	// EncodingLevels stores one byte per cell, for worlds with grey levels other than alive and dead.
+	EncodingLevels
+	// EncodingLevelsRLE stores the bytes of EncodingLevels as (run length, byte) pairs.
+	EncodingLevelsRLE
+)
+
+// Packed is the form every world, strip, halo and diff takes on the wire.
+// In the bit encodings alive cells unpack to 255 and dead cells to 0; the level encodings keep every grey level.
+type Packed struct {
+	Width    int
+	Height   int
+	Encoding Encoding
+	Data     []byte
+}
+
+// Pack encodes world, choosing whichever encoding is smallest.
+// Worlds with cells that are neither 0 nor 255 always use a level encoding.
+func Pack(world [][]uint8) Packed {
+	height := len(world)
+	width := 0
+	if height > 0 {
+		width = len(world[0])
+	}
+	for _, row := range world {
+		for _, cell := range row {
+			if cell != 0 && cell != 255 {
+				return packLevels(width, height, world)
+			}
+		}
+	}
+	bits := make([]byte, (width*height+7)/8)
+	for y, row := range world {
+		for x, cell := range row {
+			if cell != 0 {
+				i := y*width + x
+				bits[i/8] |= 0x80 >> (i % 8)
+			}
+		}
+	}
+	return packBits(width, height, bits)
+}
+
+// PackCells encodes a width x height world in which only the given cells are set.
+func PackCells(width, height int, cells []util.Cell) Packed {
+	bits := make([]byte, (width*height+7)/8)
+	for _, cell := range cells {
+		i := cell.Y*width + cell.X
+		bits[i/8] |= 0x80 >> (i % 8)
+	}
+	return packBits(width, height, bits)
+}
+
+func packBits(width, height int, bits []byte) Packed {
+	packed := Packed{Width: width, Height: height, Encoding: EncodingBits, Data: bits}
+	if rle := encodeRLE(bits); len(rle) < len(packed.Data) {
+		packed.Encoding, packed.Data = EncodingRLE, rle
+	}
+	if delta := encodeDelta(bits); len(delta) < len(packed.Data) {
+		packed.Encoding, packed.Data = EncodingDelta, delta
+	}
+	return packed
+}
+
+func packLevels(width, height int, world [][]uint8) Packed {
+	levels := make([]byte, 0, width*height)
+	for _, row := range world {
+		levels = append(levels, row...)
+	}
+	packed := Packed{Width: width, Height: height, Encoding: EncodingLevels, Data: levels}
+	if rle := encodeRLE(levels); len(rle) < len(packed.Data) {
+		packed.Encoding, packed.Data = EncodingLevelsRLE, rle
+	}
+	return packed
+}
+
+func encodeRLE(bits []byte) []byte {
+	var data []byte
+	buf := make([]byte, binary.MaxVarintLen64)
+	for i := 0; i < len(bits); {
+		run := 1
+		for i+run < len(bits) && bits[i+run] == bits[i] {
+			run++
+		}
+		n := binary.PutUvarint(buf, uint64(run))
+		data = append(data, buf[:n]...)
+		data = append(data, bits[i])
+		i += run
+	}
+	return data
+}
+
+func encodeDelta(bits []byte) []byte {
+	var data []byte
+	buf := make([]byte, binary.MaxVarintLen64)
+	last := -1
+	for i := 0; i < len(bits)*8; i++ {
+		if bits[i/8]&(0x80>>(i%8)) != 0 {
+			n := binary.PutUvarint(buf, uint64(i-last))
+			data = append(data, buf[:n]...)
+			last = i
+		}
+	}
+	return data
+}
+
+// decodeRLE expands (run length, byte) pairs back to size bytes.
+func decodeRLE(data []byte, size int) []byte {
+	bytes := make([]byte, 0, size)
+	for len(data) > 0 && len(bytes) < size {
+		run, n := binary.Uvarint(data)
+		if n <= 0 || n >= len(data) {
+			break
+		}
+		for j := uint64(0); j < run && len(bytes) < size; j++ {
+			bytes = append(bytes, data[n])
+		}
+		data = data[n+1:]
+	}
+	return pad(bytes, size)
+}
+
+// pad extends data with zeros up to size bytes, so that a truncated payload decodes to dead cells.
+func pad(data []byte, size int) []byte {
+	if len(data) >= size {
+		return data
+	}
+	return append(append([]byte(nil), data...), make([]byte, size-len(data))...)
+}
+
+// levels decodes p to one byte per cell, or returns nil if p uses a bit encoding.
+func (p Packed) levels() []byte {
+	switch p.Encoding {
+	case EncodingLevels:
+		return pad(p.Data, p.Width*p.Height)
+	case EncodingLevelsRLE:
+		return decodeRLE(p.Data, p.Width*p.Height)
+	default:
+		return nil
+	}
+}
+
+// bits decodes p back to one bit per cell.
+func (p Packed) bits() []byte {
+	size := (p.Width*p.Height + 7) / 8
+	switch p.Encoding {
+	case EncodingRLE:
+		return decodeRLE(p.Data, size)
+	case EncodingDelta:
+		bits := make([]byte, size)
+		i := -1
+		for data := p.Data; len(data) > 0; {
+			gap, n := binary.Uvarint(data)
+			if n <= 0 {
+				break
+			}
+			i += int(gap)
+			if i < 0 || i/8 >= size {
+				break
+			}
+			bits[i/8] |= 0x80 >> (i % 8)
+			data = data[n:]
+		}
+		return bits
+	case EncodingLevels, EncodingLevelsRLE:
+		bits := make([]byte, size)
+		for i, level := range p.levels() {
+			if level != 0 {
+				bits[i/8] |= 0x80 >> (i % 8)
+			}
+		}
+		return bits
+	default:
+		return pad(p.Data, size)
+	}
+}
+
+// Unpack converts p back to the [][]uint8 form used by the rest of the program.
+func (p Packed) Unpack() [][]uint8 {
+	world := make([][]uint8, p.Height)
+	if levels := p.levels(); levels != nil {
+		for y := range world {
+			world[y] = append([]uint8(nil), levels[y*p.Width:(y+1)*p.Width]...)
+		}
+		return world
+	}
+	bits := p.bits()
+	for y := range world {
+		world[y] = make([]uint8, p.Width)
+		for x := range world[y] {
+			i := y*p.Width + x
+			if bits[i/8]&(0x80>>(i%8)) != 0 {
+				world[y][x] = 255
+			}
+		}
+	}
+	return world
+}
+
+// Cells lists the cells that are set in p, which for the level encodings are the cells that are not 0.
+func (p Packed) Cells() []util.Cell {
+	bits := p.bits()
+	var cells []util.Cell
+	for i := 0; i < p.Width*p.Height; i++ {
+		if bits[i/8]&(0x80>>(i%8)) != 0 {
+			cells = append(cells, util.Cell{X: i % p.Width, Y: i / p.Width})
+		}
+	}
+	return cells
+}
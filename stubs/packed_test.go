@@ -0,0 +1,112 @@
+package stubs
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// world makes a width x height world whose cells are set by cell.
+func world(width, height int, cell func(x, y int) uint8) [][]uint8 {
+	w := make([][]uint8, height)
+	for y := range w {
+		w[y] = make([]uint8, width)
+		for x := range w[y] {
+			w[y][x] = cell(x, y)
+		}
+	}
+	return w
+}
+
+// TestPack tests that worlds come back from Pack and Unpack unchanged, and that each picks the encoding that suits it.
+func TestPack(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tests := []struct {
+		name     string
+		world    [][]uint8
+		encoding Encoding
+	}{
+		{"empty", world(13, 7, func(x, y int) uint8 { return 0 }), EncodingDelta},
+		{"full", world(13, 7, func(x, y int) uint8 { return 255 }), EncodingRLE},
+		{"sparse", world(100, 50, func(x, y int) uint8 {
+			if x == y || x == 99 && y == 49 {
+				return 255
+			}
+			return 0
+		}), EncodingDelta},
+		{"random", world(9, 9, func(x, y int) uint8 { return uint8(rng.Intn(2)) * 255 }), EncodingBits},
+		{"random rows of 8", world(8, 5, func(x, y int) uint8 { return uint8(rng.Intn(2)) * 255 }), EncodingBits},
+		{"grey levels", world(11, 3, func(x, y int) uint8 { return uint8(rng.Intn(256)) }), EncodingLevels},
+		{"few grey levels", world(70, 30, func(x, y int) uint8 {
+			if x < 3 {
+				return 128
+			}
+			return 0
+		}), EncodingLevelsRLE},
+		{"one row", world(17, 1, func(x, y int) uint8 { return uint8(x%3/2) * 255 }), EncodingBits},
+		{"nothing", [][]uint8{}, EncodingBits},
+	}
+	for _, test := range tests {
+		packed := Pack(test.world)
+		if packed.Encoding != test.encoding {
+			t.Errorf("%v: encoded as %v, want %v", test.name, packed.Encoding, test.encoding)
+		}
+		if got := packed.Unpack(); fmt.Sprint(got) != fmt.Sprint(test.world) {
+			t.Errorf("%v: unpacked to %v, want %v", test.name, got, test.world)
+		}
+	}
+}
+
+// TestPackCells tests that cells come back from PackCells and Cells in row order, and that Cells lists the cells
+// that are not dead in worlds with grey levels.
+func TestPackCells(t *testing.T) {
+	tests := []struct {
+		name   string
+		width  int
+		height int
+		cells  []util.Cell
+	}{
+		{"none", 10, 10, nil},
+		{"corners", 13, 7, []util.Cell{{X: 0, Y: 0}, {X: 12, Y: 0}, {X: 0, Y: 6}, {X: 12, Y: 6}}},
+		{"glider", 5, 5, []util.Cell{{X: 1, Y: 0}, {X: 2, Y: 1}, {X: 0, Y: 2}, {X: 1, Y: 2}, {X: 2, Y: 2}}},
+		{"far apart", 1000, 1000, []util.Cell{{X: 3, Y: 1}, {X: 999, Y: 999}}},
+	}
+	for _, test := range tests {
+		packed := PackCells(test.width, test.height, test.cells)
+		if packed.Width != test.width || packed.Height != test.height {
+			t.Errorf("%v: packed as %vx%v, want %vx%v", test.name, packed.Width, packed.Height, test.width, test.height)
+		}
+		if got := packed.Cells(); fmt.Sprint(got) != fmt.Sprint(test.cells) {
+			t.Errorf("%v: cells %v, want %v", test.name, got, test.cells)
+		}
+	}
+
+	grey := Pack([][]uint8{{0, 128, 0}, {255, 0, 1}})
+	want := []util.Cell{{X: 1, Y: 0}, {X: 0, Y: 1}, {X: 2, Y: 1}}
+	if got := grey.Cells(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("grey levels: cells %v, want %v", got, want)
+	}
+}
+
+// TestUnpackTruncated tests that data cut short unpacks to dead cells rather than failing.
+func TestUnpackTruncated(t *testing.T) {
+	bits := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	levels := make([]byte, 64)
+	for i := range levels {
+		levels[i] = 255
+	}
+	for _, packed := range []Packed{
+		{Width: 16, Height: 4, Encoding: EncodingBits, Data: bits},
+		{Width: 16, Height: 4, Encoding: EncodingRLE, Data: []byte{4, 0xff, 4, 0xff}},
+		{Width: 16, Height: 4, Encoding: EncodingLevels, Data: levels},
+		{Width: 16, Height: 4, Encoding: EncodingLevelsRLE, Data: []byte{32, 0xff, 32, 0xff}},
+	} {
+		packed.Data = packed.Data[:len(packed.Data)/2]
+		got := packed.Unpack()
+		if len(got) != 4 || got[0][0] != 255 || got[3][15] != 0 {
+			t.Errorf("%v cut short: unpacked to %v, want a 16x4 world starting alive and ending dead", packed.Encoding, got)
+		}
+	}
+}
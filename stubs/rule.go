@@ -0,0 +1,381 @@
+package stubs
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Rule is an outer-totalistic Life-like rule. Bit n of Birth is set if a dead cell with n alive
+// neighbours comes alive, and bit n of Survival is set if an alive cell with n alive neighbours stays alive.
+// The zero Rule stands for Conway's Life, so that requests from clients that do not set a rule keep their meaning.
+//
+// States counts the states a cell can be in, including dead and alive. Above 2 it makes a Generations rule:
+// an alive cell that does not survive spends States-2 turns dying before it is dead, and only alive cells
+// count as neighbours. Dying cells are stored as grey levels fading from 255 towards 0, as given by Level.
+//
+// Neighbourhood is the shape of the cells counted around each cell. Rules in B/S notation reach one cell away.
+// Larger than Life rules reach Range cells away, above 1, and replace Birth and Survival with the ranges of counts
+// at which cells are born and survive. Counts never include the cell itself: Middle records that the rule
+// was given with M1, counting it, and that SurvivalCounts has had it taken away.
+type Rule struct {
+	Birth          uint16
+	Survival       uint16
+	States         int
+	Neighbourhood  Neighbourhood
+	Range          int
+	BirthCounts    Counts
+	SurvivalCounts Counts
+	Middle         bool
+}
+
+// Counts is an inclusive range of neighbour counts.
+type Counts struct {
+	Min int
+	Max int
+}
+
+func (c Counts) contains(n int) bool {
+	return n >= c.Min && n <= c.Max
+}
+
+// Neighbourhood is the shape of the cells counted around each cell, out to the rule's range.
+type Neighbourhood int
+
+const (
+	// NeighbourhoodMoore counts the square of cells around each cell.
+	NeighbourhoodMoore Neighbourhood = iota
+	// NeighbourhoodVonNeumann counts the diamond of cells that are Range or fewer steps away, not moving diagonally.
+	NeighbourhoodVonNeumann
+	// NeighbourhoodHexagonal counts a hexagon of cells, as if each row were shifted half a cell to the left of the one below.
+	// At range 1 that is the Moore neighbourhood without the cells to the top right and bottom left.
+	NeighbourhoodHexagonal
+)
+
+// Span returns the columns, relative to a cell, that n covers in the row dy rows below it at range r.
+func (n Neighbourhood) Span(dy, r int) (lo, hi int) {
+	switch n {
+	case NeighbourhoodVonNeumann:
+		if dy < 0 {
+			dy = -dy
+		}
+		return dy - r, r - dy
+	case NeighbourhoodHexagonal:
+		lo, hi = -r, r
+		if dy > 0 {
+			lo = dy - r
+		} else {
+			hi = dy + r
+		}
+		return lo, hi
+	default:
+		return -r, r
+	}
+}
+
+// Size is the number of cells n covers at range r, not counting the cell in the middle.
+func (n Neighbourhood) Size(r int) int {
+	size := 0
+	for dy := -r; dy <= r; dy++ {
+		lo, hi := n.Span(dy, r)
+		size += hi - lo + 1
+	}
+	return size - 1
+}
+
+// neighbourhoodSuffixes are the letters that pick a neighbourhood after a rule in B/S notation,
+// and after N in Larger than Life notation.
+var neighbourhoodSuffixes = map[Neighbourhood]string{
+	NeighbourhoodMoore:      "m",
+	NeighbourhoodVonNeumann: "v",
+	NeighbourhoodHexagonal:  "h",
+}
+
+// maxRange is the largest range a Larger than Life rule may reach.
+const maxRange = 500
+
+// Life is Conway's Game of Life, B3/S23.
+var Life = Rule{Birth: 1 << 3, Survival: 1<<2 | 1<<3}
+
+// namedRules maps the names ParseRule accepts to their rules in B/S notation.
+var namedRules = map[string]string{
+	"life":             "B3/S23",
+	"conway":           "B3/S23",
+	"highlife":         "B36/S23",
+	"daynight":         "B3678/S34678",
+	"seeds":            "B2/S",
+	"lifewithoutdeath": "B3/S012345678",
+	"replicator":       "B1357/S1357",
+	"2x2":              "B36/S125",
+	"maze":             "B3/S12345",
+	"diamoeba":         "B35678/S5678",
+	"morley":           "B368/S245",
+	"anneal":           "B4678/S35678",
+	"briansbrain":      "B2/S/3",
+	"starwars":         "B2/S345/4",
+	"frogs":            "B34/S12/3",
+	"swirl":            "B34/S23/8",
+	"bloomerang":       "B34678/S234/24",
+	"hexlife":          "B2/S34H",
+	"bosco":            "R5,C0,M1,S34..58,B34..45,NM",
+	"bugs":             "R5,C0,M1,S34..58,B34..45,NM",
+	"majority":         "R4,C0,M1,S41..81,B41..81,NM",
+	"waffle":           "R7,C0,M1,S100..200,B75..170,NM",
+	"globe":            "R8,C0,M0,S163..223,B74..252,NM",
+}
+
+// ParseRule reads a rule in B/S notation such as "B36/S23", in S/B notation such as "23/36",
+// or by name, such as "HighLife" or "Day & Night". Generations rules add the number of states,
+// as in "B2/S/3", "B2/S/C3" or "/2/3", and a trailing H or V picks the hexagonal or von Neumann
+// neighbourhood, as in "B2/S34H". Larger than Life rules are given as in "R5,C0,M1,S34..58,B34..45,NM".
+func ParseRule(s string) (Rule, error) {
+	name := strings.ToLower(strings.NewReplacer(" ", "", "&", "", "-", "", "_", "").Replace(s))
+	if bs, ok := namedRules[name]; ok {
+		name = strings.ToLower(bs)
+	}
+	if strings.Contains(name, ",") {
+		return parseLargerThanLife(s, name)
+	}
+
+	var neighbourhood Neighbourhood
+	for n, suffix := range neighbourhoodSuffixes {
+		if n != NeighbourhoodMoore && strings.HasSuffix(name, suffix) {
+			neighbourhood, name = n, strings.TrimSuffix(name, suffix)
+		}
+	}
+
+	parts := strings.Split(name, "/")
+	if len(parts) != 2 && len(parts) != 3 {
+		return Rule{}, fmt.Errorf("rule %q is not of the form B3/S23 or B3/S23/4", s)
+	}
+	var birth, survival string
+	switch {
+	case strings.HasPrefix(parts[0], "b") && strings.HasPrefix(parts[1], "s"):
+		birth, survival = parts[0][1:], parts[1][1:]
+	case strings.HasPrefix(parts[0], "s") && strings.HasPrefix(parts[1], "b"):
+		survival, birth = parts[0][1:], parts[1][1:]
+	default:
+		survival, birth = parts[0], parts[1]
+	}
+
+	r := Rule{Neighbourhood: neighbourhood}
+	if len(parts) == 3 {
+		states, err := strconv.Atoi(strings.TrimPrefix(parts[2], "c"))
+		if err != nil || states < 2 || states > 256 {
+			return Rule{}, fmt.Errorf("rule %q: the number of states must be between 2 and 256", s)
+		}
+		r.States = states
+	}
+	var err error
+	r.Birth, err = neighbourCounts(birth)
+	if err != nil {
+		return Rule{}, fmt.Errorf("rule %q: %v", s, err)
+	}
+	r.Survival, err = neighbourCounts(survival)
+	if err != nil {
+		return Rule{}, fmt.Errorf("rule %q: %v", s, err)
+	}
+	if size := neighbourhood.Size(1); r.Birth>>(size+1) != 0 || r.Survival>>(size+1) != 0 {
+		return Rule{}, fmt.Errorf("rule %q: the neighbourhood only has %v cells", s, size)
+	}
+	return r, nil
+}
+
+func neighbourCounts(digits string) (uint16, error) {
+	var counts uint16
+	for _, digit := range digits {
+		if digit < '0' || digit > '8' {
+			return 0, fmt.Errorf("%q is not a neighbour count between 0 and 8", digit)
+		}
+		counts |= 1 << (digit - '0')
+	}
+	return counts, nil
+}
+
+// parseLargerThanLife reads a rule in the notation Golly uses for Larger than Life, such as "R5,C0,M1,S34..58,B34..45,NM",
+// from name, the lower case form of s. M1 counts the cell itself, which is folded into SurvivalCounts.
+// A rule of range 1 is turned into B/S form, so that it runs like any other.
+func parseLargerThanLife(s, name string) (Rule, error) {
+	r := Rule{Range: -1, BirthCounts: Counts{-1, -1}, SurvivalCounts: Counts{-1, -1}}
+	middle := false
+	for _, field := range strings.Split(name, ",") {
+		if field == "" {
+			return Rule{}, fmt.Errorf("rule %q has an empty field", s)
+		}
+		value := field[1:]
+		var err error
+		switch field[0] {
+		case 'r':
+			r.Range, err = strconv.Atoi(value)
+			if err == nil && (r.Range < 1 || r.Range > maxRange) {
+				err = fmt.Errorf("the range must be between 1 and %v", maxRange)
+			}
+		case 'c':
+			r.States, err = strconv.Atoi(value)
+			if err == nil && (r.States < 0 || r.States > 256) {
+				err = errors.New("the number of states must be between 2 and 256")
+			}
+		case 'm':
+			if value != "0" && value != "1" {
+				err = errors.New("M must be 0 or 1")
+			}
+			middle = value == "1"
+		case 's':
+			r.SurvivalCounts, err = countRange(value)
+		case 'b':
+			r.BirthCounts, err = countRange(value)
+		case 'n':
+			found := false
+			for n, suffix := range neighbourhoodSuffixes {
+				if value == suffix || n == NeighbourhoodVonNeumann && value == "n" {
+					r.Neighbourhood, found = n, true
+				}
+			}
+			if !found {
+				err = fmt.Errorf("unknown neighbourhood %q, expected M, N or H", value)
+			}
+		default:
+			err = fmt.Errorf("unknown field %q", field)
+		}
+		if err != nil {
+			return Rule{}, fmt.Errorf("rule %q: %v", s, err)
+		}
+	}
+	if r.Range < 0 || r.BirthCounts.Min < 0 || r.SurvivalCounts.Min < 0 {
+		return Rule{}, fmt.Errorf("rule %q needs at least R, S and B fields", s)
+	}
+	if r.States < 3 {
+		r.States = 0
+	}
+	if middle {
+		r.SurvivalCounts.Min--
+		r.SurvivalCounts.Max--
+	}
+
+	if r.Range > 1 {
+		r.Middle = middle
+		return r, nil
+	}
+	for n := 0; n <= r.Neighbourhood.Size(1); n++ {
+		if r.BirthCounts.contains(n) {
+			r.Birth |= 1 << n
+		}
+		if r.SurvivalCounts.contains(n) {
+			r.Survival |= 1 << n
+		}
+	}
+	r.Range, r.BirthCounts, r.SurvivalCounts = 0, Counts{}, Counts{}
+	return r, nil
+}
+
+// countRange reads a range of counts such as "34..58", or a single count such as "3".
+func countRange(s string) (Counts, error) {
+	low, high := s, s
+	if i := strings.Index(s, ".."); i >= 0 {
+		low, high = s[:i], s[i+2:]
+	}
+	var c Counts
+	var err error
+	c.Min, err = strconv.Atoi(low)
+	if err == nil {
+		c.Max, err = strconv.Atoi(high)
+	}
+	if err != nil || c.Min < 0 || c.Max < c.Min {
+		return Counts{}, fmt.Errorf("%q is not a range of counts such as 34..58", s)
+	}
+	return c, nil
+}
+
+// LargerThanLife reports whether r reaches further than one cell away.
+func (r Rule) LargerThanLife() bool {
+	return r.Range > 1
+}
+
+// Radius is the number of cells r reaches away from each cell, and so the number of rows
+// each side of a strip that a worker needs to step it.
+func (r Rule) Radius() int {
+	if r.LargerThanLife() {
+		return r.Range
+	}
+	return 1
+}
+
+// Born reports whether a dead cell with n alive neighbours comes alive under r.
+func (r Rule) Born(n int) bool {
+	if r.LargerThanLife() {
+		return r.BirthCounts.contains(n)
+	}
+	return n < 16 && r.Birth&(1<<n) != 0
+}
+
+// Survives reports whether an alive cell with n alive neighbours stays alive under r.
+func (r Rule) Survives(n int) bool {
+	if r.LargerThanLife() {
+		return r.SurvivalCounts.contains(n)
+	}
+	return n < 16 && r.Survival&(1<<n) != 0
+}
+
+// Generations reports whether r has dying states between alive and dead.
+func (r Rule) Generations() bool {
+	return r.States > 2
+}
+
+// Level is the grey level of state k of r, counting alive as 1 and the dying states from 2 to States-1.
+// Dead cells, state 0, are 0.
+func (r Rule) Level(k int) uint8 {
+	switch {
+	case k == 1:
+		return 255
+	case k < 1 || k >= r.States:
+		return 0
+	default:
+		return uint8(255 - (k-1)*255/(r.States-1))
+	}
+}
+
+// OrLife returns r, or Life if r is the zero Rule.
+func (r Rule) OrLife() Rule {
+	if r == (Rule{}) {
+		return Life
+	}
+	return r
+}
+
+// String writes r in B/S notation, or in Larger than Life notation if it reaches further than one cell away.
+func (r Rule) String() string {
+	r = r.OrLife()
+	if r.LargerThanLife() {
+		neighbourhood := strings.ToUpper(neighbourhoodSuffixes[r.Neighbourhood])
+		if r.Neighbourhood == NeighbourhoodVonNeumann {
+			neighbourhood = "N"
+		}
+		middle, survival := 0, r.SurvivalCounts
+		if r.Middle {
+			middle, survival.Min, survival.Max = 1, survival.Min+1, survival.Max+1
+		}
+		return fmt.Sprintf("R%v,C%v,M%v,S%v..%v,B%v..%v,N%v", r.Range, r.States, middle,
+			survival.Min, survival.Max, r.BirthCounts.Min, r.BirthCounts.Max, neighbourhood)
+	}
+	var b strings.Builder
+	b.WriteString("B")
+	for n := 0; n <= 8; n++ {
+		if r.Birth&(1<<n) != 0 {
+			fmt.Fprint(&b, n)
+		}
+	}
+	b.WriteString("/S")
+	for n := 0; n <= 8; n++ {
+		if r.Survival&(1<<n) != 0 {
+			fmt.Fprint(&b, n)
+		}
+	}
+	if r.Generations() {
+		fmt.Fprintf(&b, "/%v", r.States)
+	}
+	if r.Neighbourhood != NeighbourhoodMoore {
+		b.WriteString(strings.ToUpper(neighbourhoodSuffixes[r.Neighbourhood]))
+	}
+	return b.String()
+}
@@ -0,0 +1,162 @@
+package stubs
+
+import "testing"
+
+// TestParseRule tests rules in B/S and S/B notation and by name, and that they are written back in B/S notation.
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string
+	}{
+		{"B3/S23", "B3/S23"},
+		{"b3/s23", "B3/S23"},
+		{"S23/B3", "B3/S23"},
+		{"23/3", "B3/S23"},
+		{"B36/S23", "B36/S23"},
+		{"B2/S", "B2/S"},
+		{"B/S012345678", "B/S012345678"},
+		{"B0/S8", "B0/S8"},
+		{"HighLife", "B36/S23"},
+		{"Day & Night", "B3678/S34678"},
+		{"seeds", "B2/S"},
+	}
+	for _, test := range tests {
+		r, err := ParseRule(test.rule)
+		if err != nil {
+			t.Errorf("ParseRule(%q): %v", test.rule, err)
+			continue
+		}
+		if got := r.String(); got != test.want {
+			t.Errorf("ParseRule(%q) = %v, want %v", test.rule, got, test.want)
+		}
+		again, err := ParseRule(r.String())
+		if err != nil || again != r {
+			t.Errorf("ParseRule(%q) = %+v, %v, want %+v", r.String(), again, err, r)
+		}
+	}
+}
+
+// TestParseRuleErrors tests that malformed rules are rejected.
+func TestParseRuleErrors(t *testing.T) {
+	for _, rule := range []string{"", "B3", "B3/S23/S4/5", "B9/S23", "B3/S2x", "Q3/S23", "not a rule"} {
+		if r, err := ParseRule(rule); err == nil {
+			t.Errorf("ParseRule(%q) = %v, want an error", rule, r)
+		}
+	}
+}
+
+// TestZeroRule tests that the zero Rule stands for Conway's Life.
+func TestZeroRule(t *testing.T) {
+	if got := (Rule{}).OrLife(); got != Life {
+		t.Errorf("Rule{}.OrLife() = %v, want %v", got, Life)
+	}
+	if got := (Rule{}).String(); got != "B3/S23" {
+		t.Errorf("Rule{}.String() = %v, want B3/S23", got)
+	}
+}
+
+// TestParseGenerations tests Generations rules and the grey levels of their dying states.
+func TestParseGenerations(t *testing.T) {
+	tests := []struct {
+		rule   string
+		want   string
+		states int
+	}{
+		{"B2/S/3", "B2/S/3", 3},
+		{"B2/S/C3", "B2/S/3", 3},
+		{"/2/3", "B2/S/3", 3},
+		{"Brians Brain", "B2/S/3", 3},
+		{"B34678/S234/24", "B34678/S234/24", 24},
+		{"B3/S23/2", "B3/S23", 2},
+		{"B3/S23/256", "B3/S23/256", 256},
+	}
+	for _, test := range tests {
+		r, err := ParseRule(test.rule)
+		if err != nil {
+			t.Errorf("ParseRule(%q): %v", test.rule, err)
+			continue
+		}
+		if got := r.String(); got != test.want || r.States != test.states {
+			t.Errorf("ParseRule(%q) = %v with %v states, want %v with %v", test.rule, got, r.States, test.want, test.states)
+		}
+	}
+	for _, rule := range []string{"B2/S/1", "B2/S/257", "B2/S/x", "B2/S/C"} {
+		if r, err := ParseRule(rule); err == nil {
+			t.Errorf("ParseRule(%q) = %v, want an error", rule, r)
+		}
+	}
+}
+
+// TestLevel tests that alive cells are white, dead cells black and dying cells fade evenly in between.
+func TestLevel(t *testing.T) {
+	tests := []struct {
+		states int
+		levels []uint8
+	}{
+		{0, []uint8{0, 255, 0}},
+		{2, []uint8{0, 255, 0}},
+		{3, []uint8{0, 255, 128, 0}},
+		{4, []uint8{0, 255, 170, 85, 0}},
+		{6, []uint8{0, 255, 204, 153, 102, 51, 0}},
+	}
+	for _, test := range tests {
+		r := Rule{Birth: 1 << 2, States: test.states}
+		for k, want := range test.levels {
+			if got := r.Level(k); got != want {
+				t.Errorf("Level(%v) with %v states = %v, want %v", k, test.states, got, want)
+			}
+		}
+	}
+}
+
+// TestParseNeighbourhoods tests hexagonal, von Neumann and Larger than Life rules, and that Larger than Life rules
+// are written back with the M flag and counts they were given.
+func TestParseNeighbourhoods(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string
+	}{
+		{"B2/S34H", "B2/S34H"},
+		{"b2/s34h", "B2/S34H"},
+		{"B1/S1V", "B1/S1V"},
+		{"B2/S/3H", "B2/S/3H"},
+		{"R5,C0,M1,S34..58,B34..45,NM", "R5,C0,M1,S34..58,B34..45,NM"},
+		{"r5,c0,m1,s34..58,b34..45,nm", "R5,C0,M1,S34..58,B34..45,NM"},
+		{"R2,C0,M1,S0..5,B1..3,NM", "R2,C0,M1,S0..5,B1..3,NM"},
+		{"R2,C0,M0,S0..3,B2..2,NN", "R2,C0,M0,S0..3,B2..2,NN"},
+		{"R3,C4,M0,S2..9,B4,NH", "R3,C4,M0,S2..9,B4..4,NH"},
+		{"R1,C0,M0,S2..3,B3..3,NM", "B3/S23"},
+		{"R1,C0,M1,S3..4,B3..3,NM", "B3/S23"},
+		{"R1,C0,M0,S1..2,B1..1,NN", "B1/S12V"},
+	}
+	for _, test := range tests {
+		r, err := ParseRule(test.rule)
+		if err != nil {
+			t.Errorf("ParseRule(%q): %v", test.rule, err)
+			continue
+		}
+		if got := r.String(); got != test.want {
+			t.Errorf("ParseRule(%q) = %v, want %v", test.rule, got, test.want)
+		}
+		again, err := ParseRule(r.String())
+		if err != nil || again != r {
+			t.Errorf("ParseRule(%q) = %+v, %v, want %+v", r.String(), again, err, r)
+		}
+	}
+
+	// M1 counts the cell itself, so a surviving cell needs one fewer neighbour.
+	r, _ := ParseRule("R5,C0,M1,S34..58,B34..45,NM")
+	if r.Survives(32) || !r.Survives(33) || !r.Survives(57) || r.Survives(58) {
+		t.Errorf("%v survives with counts %+v, want 33..57", r, r.SurvivalCounts)
+	}
+
+	for _, rule := range []string{
+		"B7/S23H", "B5/S23V", "R0,C0,M0,S2..3,B3..3,NM", "R501,C0,M0,S2..3,B3..3,NM", "R5,C0,M2,S34..58,B34..45,NM",
+		"R5,C0,M1,S34..58,NM", "R5,C0,M1,S58..34,B34..45,NM", "R5,C0,M1,S34..58,B34..45,NX", "R5,,S34..58,B34..45",
+		"R5,C257,M0,S34..58,B34..45,NM", "R5,C0,M0,S34..,B34..45,NM",
+	} {
+		if r, err := ParseRule(rule); err == nil {
+			t.Errorf("ParseRule(%q) = %v, want an error", rule, r)
+		}
+	}
+}
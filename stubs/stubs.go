@@ -1,18 +1,199 @@
 package stubs
 
+import "time"
+
+// GolHandler runs a version 1 request to completion in a single call.
 var GolHandler = "GolOperations.Evolve"
 var CellReport = "GolOperations.CountAlive"
+var StartHandler = "GolOperations.Start"
+var AwaitHandler = "GolOperations.Await"
+var PauseHandler = "GolOperations.Pause"
+var ResumeHandler = "GolOperations.Resume"
+var SaveHandler = "GolOperations.Save"
+var QuitHandler = "GolOperations.Quit"
+var AttachHandler = "GolOperations.Attach"
+var ShutdownHandler = "GolOperations.Shutdown"
+var FlippedHandler = "GolOperations.Flipped"
+var DetachHandler = "GolOperations.Detach"
+var RestoreHandler = "GolOperations.Restore"
+var ListHandler = "GolOperations.List"
+var CancelHandler = "GolOperations.Cancel"
+
+var RegisterHandler = "GolOperations.Register"
+var StripInitHandler = "GolOperations.InitStrip"
+var StripStepHandler = "GolOperations.StepStrip"
+var StripCollectHandler = "GolOperations.CollectStrip"
+var StripFreeHandler = "GolOperations.FreeStrip"
+var HeartbeatHandler = "GolOperations.Heartbeat"
 
 type Response struct {
-	FinalWorld     [][]uint8
+	FinalWorld     Packed
 	CompletedTurns int
 	AliveCells     int
+	Session        string
+	Paused         bool
+	// Warnings lists problems the session has recovered from since they were last reported.
+	Warnings []string
+	// SkippedTiles is the fraction of tiles the sparse engine has left alone since the last CountAlive call.
+	SkippedTiles float64
+	// CyclePeriod is how many turns apart the world repeats itself, 1 for a still life, once a session
+	// watching for cycles has found it repeating. It is 0 until then. CycleStart is the first turn of the cycle.
+	CycleStart  int
+	CyclePeriod int
 }
 
-type Request struct {
+// Stream modes decide how a session reports the cells flipped by each turn.
+const (
+	// StreamOff reports nothing, so the run is never held up by the controller.
+	StreamOff = iota
+	// StreamTurns reports every turn separately. The run waits for the controller if it falls behind.
+	StreamTurns
+	// StreamFrames merges all turns since the last Flipped call into one diff.
+	StreamFrames
+)
+
+// RequestVersion is the version of Request sent by this client. Servers reject requests of any other version.
+// Version 1 clients send a RequestV1 to GolHandler instead, which servers still answer.
+const RequestVersion = 2
+
+// RequestV1 is the request of version 1 clients, which carried the world unpacked along with a NewWorld
+// scratch buffer for the server to step into. Servers ignore NewWorld and run the world under Conway's Life on a torus.
+type RequestV1 struct {
 	World       [][]uint8
 	NewWorld    [][]uint8
 	ImageHeight int
 	ImageWidth  int
 	Turns       int
 }
+
+// ResponseV1 is the answer to a RequestV1.
+type ResponseV1 struct {
+	FinalWorld     [][]uint8
+	CompletedTurns int
+	AliveCells     int
+}
+
+type Request struct {
+	Version int
+	// Owner names whoever started the session. Only they may cancel it.
+	Owner       string
+	World       Packed
+	ImageHeight int
+	ImageWidth  int
+	Turns       int
+	Threads     int
+	Rule        Rule
+	Boundary    Boundary
+	Engine      Engine
+	Cycles      Cycles
+	Stream      int
+	// Paused starts the session paused until Resume is called.
+	Paused bool
+}
+
+// ControlRequest asks about or acts on a running session.
+// Stream is only used by Attach, to choose how the new controller is sent flipped cells,
+// and Owner only by Cancel.
+type ControlRequest struct {
+	Session string
+	Stream  int
+	Owner   string
+}
+
+type ListRequest struct {
+}
+
+// SessionInfo describes a session on the server.
+type SessionInfo struct {
+	Session        string
+	Owner          string
+	Started        time.Time
+	ImageWidth     int
+	ImageHeight    int
+	Rule           Rule
+	Boundary       Boundary
+	CompletedTurns int
+	Turns          int
+	AliveCells     int
+	Paused         bool
+	// Detached is set while no controller is attached to the session.
+	Detached bool
+	// Finished is set once the run has ended but its final world has not been collected yet.
+	Finished bool
+}
+
+type ListResponse struct {
+	Sessions []SessionInfo
+}
+
+// RestoreRequest asks the server to resume a session from its latest checkpoint on disk.
+// An empty Session picks the most recently saved checkpoint, which must be ImageWidth x ImageHeight.
+type RestoreRequest struct {
+	Session     string
+	ImageHeight int
+	ImageWidth  int
+	Stream      int
+	Paused      bool
+}
+
+// TurnDiff marks the cells flipped to reach CompletedTurns.
+// For Generations rules, Levels holds the grey level each flipped cell changed to, in the order Flipped lists them.
+type TurnDiff struct {
+	CompletedTurns int
+	Flipped        Packed
+	Levels         []uint8
+}
+
+// FlippedResponse carries the diffs streamed since the last Flipped call.
+// Done is set once the session has finished and every diff has been delivered.
+type FlippedResponse struct {
+	Turns []TurnDiff
+	Done  bool
+}
+
+// RegisterRequest is sent by a worker to the broker when it starts up.
+type RegisterRequest struct {
+	Address string
+}
+
+type RegisterResponse struct {
+}
+
+// StripRequest hands a worker the rows of the world it is responsible for.
+// Only ID is needed when collecting or freeing the strip.
+type StripRequest struct {
+	ID         int
+	Strip      Packed
+	ImageWidth int
+	Threads    int
+	Rule       Rule
+	Boundary   Boundary
+}
+
+type StripResponse struct {
+	Strip Packed
+}
+
+// HaloRequest asks a worker to step its strip once using the neighbouring strips' edge rows,
+// as many of them as the rule's radius. Track asks the worker to list the cells it flipped,
+// and Hash to hash each row of the new strip.
+type HaloRequest struct {
+	ID     int
+	Top    Packed
+	Bottom Packed
+	Track  bool
+	Hash   bool
+}
+
+// HaloResponse carries a strip's new edge rows back to the broker for the next turn,
+// along with the number of alive cells left in the strip and, if tracked, the cells it flipped.
+type HaloResponse struct {
+	Top     Packed
+	Bottom  Packed
+	Alive   int
+	Flipped Packed
+	// Levels holds the new grey level of each flipped cell, in the order Flipped lists them, for Generations rules.
+	Levels []uint8
+	// Hashes holds the hash of each row of the strip, if asked for.
+	Hashes []uint64
+}